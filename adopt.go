@@ -0,0 +1,224 @@
+package dockerlib
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AdoptOption configures how Adopt and Reconnect manage the containers they adopt.
+type AdoptOption func(*adoptOptions)
+
+type adoptOptions struct {
+	ready     WaitStrategy
+	consumers []LogConsumer
+	parser    LogParser
+}
+
+// WithAdoptWaitStrategy determines the readiness of the adopted containers using the strategy, instead of
+// considering them ready as soon as they are adopted. Since the output of a container is followed from the start,
+// a LogStrategy also matches lines logged before the container was adopted.
+func WithAdoptWaitStrategy(ready WaitStrategy) AdoptOption {
+	return func(o *adoptOptions) {
+		o.ready = ready
+	}
+}
+
+// WithAdoptLogConsumers sets the LogConsumers of the adopted containers.
+func WithAdoptLogConsumers(consumers ...LogConsumer) AdoptOption {
+	return func(o *adoptOptions) {
+		o.consumers = consumers
+	}
+}
+
+// WithAdoptLogParser sets the LogParser of the adopted containers.
+func WithAdoptLogParser(parser LogParser) AdoptOption {
+	return func(o *adoptOptions) {
+		o.parser = parser
+	}
+}
+
+// AdoptedContainer is a container adopted by Adopt or Reconnect, along with its readiness.
+type AdoptedContainer struct {
+	Container
+	Readiness *Readiness
+}
+
+// Adopt takes over the running containers and the networks matching filter (e.g. a label or name filter), which
+// were typically started by an earlier process, as if they had been started by the controller: their logs are
+// followed (from the start), their readiness is determined again, and they are shut down by ShutdownAll (unless they
+// were started with Reuse), while the networks are removed by CleanupNetworks. Like the Docker CLI, a name filter
+// matches any name containing it. Only networks created by dockerlib (see ManagedLabel) or attached to an adopted
+// container are adopted, and never the predefined bridge, host and none networks.
+//
+// The Container of each adopted container is rebuilt from what Docker reports about it, so its Environment also
+// includes the variables set by the image. Containers the controller already manages are skipped.
+func (controller *DockerController) Adopt(ctx context.Context, filter filters.Args, opts ...AdoptOption) ([]AdoptedContainer, error) {
+	var options adoptOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	containers, err := controller.cli.ContainerList(ctx, types.ContainerListOptions{Filters: filter})
+	if err != nil {
+		controller.log().Errorf("Unable to list containers: %v", err)
+		return nil, DockerError{"unable to list containers", err}
+	}
+
+	managed := make(map[string]bool)
+	for _, c := range controller.runningContainers() {
+		managed[c.ID] = true
+	}
+
+	var adopted []AdoptedContainer
+	attached := make(map[string]bool)
+	for _, listed := range containers {
+		if managed[listed.ID] {
+			continue
+		}
+
+		info, err := controller.cli.ContainerInspect(ctx, listed.ID)
+		if err != nil {
+			controller.log().Errorf("Unable to inspect container %s: %v", containerName(listed), err)
+			return adopted, ContainerError{"unable to inspect container", containerName(listed), err}
+		}
+
+		if info.NetworkSettings != nil {
+			for _, endpoint := range info.NetworkSettings.Networks {
+				if endpoint != nil {
+					attached[endpoint.NetworkID] = true
+				}
+			}
+		}
+
+		c := containerFromInspect(info)
+		c.LogConsumers = options.consumers
+		c.LogParser = options.parser
+
+		// the output of a container with a TTY isn't multiplexed
+		follow := followOptions{tty: info.Config != nil && info.Config.Tty}
+
+		controller.log().Infof("Adopting container %s", c)
+		readiness := controller.track(c, options.ready, follow)
+		adopted = append(adopted, AdoptedContainer{Container: c, Readiness: readiness})
+	}
+
+	networks, err := controller.cli.NetworkList(ctx, types.NetworkListOptions{Filters: filter})
+	if err != nil {
+		controller.log().Errorf("Unable to list networks: %v", err)
+		return adopted, DockerError{"unable to list networks", err}
+	}
+
+	controller.mu.Lock()
+	defer controller.mu.Unlock()
+	for _, network := range networks {
+		// predefined networks can't be removed, and unrelated networks shouldn't be
+		if predefinedNetworks[network.Name] || !attached[network.ID] && network.Labels[ManagedLabel] != "true" {
+			continue
+		}
+
+		controller.log().Infof("Adopting network %s", network.Name)
+		controller.adopted[network.ID] = network.Name
+	}
+
+	return adopted, nil
+}
+
+// predefinedNetworks are the networks Docker creates itself, which can't be removed.
+var predefinedNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+// Reconnect adopts (see Adopt) the running containers and the networks of the controller's session. Together with
+// WithSessionID, it lets a restarted process manage the containers it started before.
+func (controller *DockerController) Reconnect(ctx context.Context, opts ...AdoptOption) ([]AdoptedContainer, error) {
+	return controller.Adopt(ctx, controller.sessionFilter(), opts...)
+}
+
+// containerFromInspect rebuilds the Container that a container was started with from its low-level information.
+func containerFromInspect(info types.ContainerJSON) Container {
+	var c Container
+	if info.ContainerJSONBase != nil {
+		c.ID = info.ID
+		c.Name = strings.TrimPrefix(info.Name, "/")
+	}
+
+	if info.Config != nil {
+		c.Image = info.Config.Image
+		c.Command = info.Config.Cmd
+		c.Environment = info.Config.Env
+
+		if health := info.Config.Healthcheck; health != nil {
+			c.HealthCheck = &HealthCheck{
+				Test:        health.Test,
+				Interval:    health.Interval,
+				Timeout:     health.Timeout,
+				StartPeriod: health.StartPeriod,
+				Retries:     health.Retries,
+			}
+		}
+
+		c.Reuse = len(info.Config.Labels[ConfigHashLabel]) > 0
+		for key, value := range info.Config.Labels {
+			if key == ManagedLabel || key == SessionLabel || key == ConfigHashLabel {
+				continue
+			}
+			if c.Labels == nil {
+				c.Labels = make(map[string]string)
+			}
+			c.Labels[key] = value
+		}
+	}
+
+	if hostConfig := info.HostConfig; hostConfig != nil {
+		c.Mounts = hostConfig.Mounts
+
+		for port, bindings := range hostConfig.PortBindings {
+			if port.Proto() != "tcp" || len(bindings) == 0 {
+				continue
+			}
+			if c.Ports == nil {
+				c.Ports = make(map[int]int)
+			}
+			// a port published on a random host port is looked up when needed, like for a started container
+			hostPort, _ := strconv.Atoi(bindings[0].HostPort)
+			c.Ports[port.Int()] = hostPort
+		}
+
+		c.Resources = Resources{
+			Memory:     hostConfig.Memory,
+			MemorySwap: hostConfig.MemorySwap,
+			NanoCPUs:   hostConfig.NanoCPUs,
+			CPUShares:  hostConfig.CPUShares,
+			CpusetCpus: hostConfig.CpusetCpus,
+			ShmSize:    hostConfig.ShmSize,
+		}
+		if hostConfig.PidsLimit != nil {
+			c.Resources.PidsLimit = *hostConfig.PidsLimit
+		}
+		for _, ulimit := range hostConfig.Ulimits {
+			c.Resources.Ulimits = append(c.Resources.Ulimits, Ulimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+		}
+	}
+
+	if info.NetworkSettings != nil {
+		// the network the container was created in isn't attached by Start
+		mode := "bridge"
+		if info.HostConfig != nil && !info.HostConfig.NetworkMode.IsDefault() && len(info.HostConfig.NetworkMode) > 0 {
+			mode = string(info.HostConfig.NetworkMode)
+		}
+
+		for name := range info.NetworkSettings.Networks {
+			if name != mode {
+				c.Network = append(c.Network, name)
+			}
+		}
+		sort.Strings(c.Network)
+	}
+
+	return c
+}
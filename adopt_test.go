@@ -0,0 +1,296 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconnect(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	engine.Script("dockerlib-test-adopted", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{dockerlibtest.Stdout("INFO:root:Hello!")},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	previous := dockerlib.NewDockerControllerFromClient(engine)
+	err := previous.EnsureNetwork(ctx, "dockerlib-test")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	started := dockerlib.Container{
+		Name:        "dockerlib-test-adopted",
+		Image:       TestImage,
+		Ports:       map[int]int{8080: 18080, 9000: 0},
+		Command:     []string{"serve"},
+		Environment: []string{"MODE=test"},
+		Network:     []string{"dockerlib-test"},
+		Mounts:      []mount.Mount{{Type: mount.TypeBind, Source: "/tmp", Target: "/data"}},
+		Labels:      map[string]string{"team": "platform"},
+		HealthCheck: &dockerlib.HealthCheck{Test: []string{"CMD", "true"}, Interval: time.Second},
+		Resources:   dockerlib.Resources{Memory: 64 * 1024 * 1024, PidsLimit: 100},
+	}
+	_, err = previous.Start(ctx, &started, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	// a restarted process resumes the session of the previous one
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithSessionID(previous.SessionID()))
+
+	var consumed []dockerlib.LogLine
+	adopted, err := controller.Reconnect(ctx,
+		dockerlib.WithAdoptWaitStrategy(dockerlib.ForLog("Hello")),
+		dockerlib.WithAdoptLogConsumers(dockerlib.LogFunc(func(line dockerlib.LogLine) {
+			consumed = append(consumed, line)
+		})))
+	if err != nil {
+		t.Fatalf("unexpected error when reconnecting: %v", err)
+	}
+
+	if len(adopted) != 1 {
+		t.Fatalf("expected a single container to be adopted, got %v", adopted)
+	}
+
+	err = adopted[0].Readiness.Wait(ctx)
+	if err != nil {
+		t.Fatalf("expected adopted container to become ready: %v", err)
+	}
+
+	got := adopted[0].Container
+	if got.ID != started.ID || got.Name != started.Name || got.Image != started.Image {
+		t.Errorf("expected %v to be adopted, got %v", started, got)
+	}
+	if !reflect.DeepEqual(got.Ports, started.Ports) {
+		t.Errorf("expected ports %v, got %v", started.Ports, got.Ports)
+	}
+	if !reflect.DeepEqual(got.Command, started.Command) || !reflect.DeepEqual(got.Environment, started.Environment) {
+		t.Errorf("expected command %v and environment %v, got %v and %v", started.Command, started.Environment, got.Command, got.Environment)
+	}
+	if !reflect.DeepEqual(got.Network, started.Network) {
+		t.Errorf("expected networks %v, got %v", started.Network, got.Network)
+	}
+	if len(got.Mounts) != 1 || got.Mounts[0].Source != "/tmp" || got.Mounts[0].Target != "/data" {
+		t.Errorf("expected mounts %v, got %v", started.Mounts, got.Mounts)
+	}
+	if !reflect.DeepEqual(got.Labels, started.Labels) {
+		t.Errorf("expected labels %v, got %v", started.Labels, got.Labels)
+	}
+	if got.HealthCheck == nil || got.HealthCheck.Interval != time.Second || got.Resources.Memory != started.Resources.Memory || got.Resources.PidsLimit != 100 {
+		t.Errorf("expected health check and resources to be adopted, got %+v and %+v", got.HealthCheck, got.Resources)
+	}
+
+	if lines := controller.Logs(started.Name); len(lines) != 1 || lines[0].Text != "INFO:root:Hello!" {
+		t.Errorf("expected logs of adopted container to be followed, got %v", lines)
+	}
+	if len(consumed) != 1 {
+		t.Errorf("expected log consumer to receive output, got %v", consumed)
+	}
+
+	// adopting again doesn't adopt the same container twice
+	adopted, err = controller.Reconnect(ctx)
+	if err != nil || len(adopted) != 0 {
+		t.Errorf("expected no container to be adopted again, got %v (%v)", adopted, err)
+	}
+
+	err = controller.ShutdownAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down containers: %v", err)
+	}
+	err = controller.CleanupNetworks(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when cleaning up networks: %v", err)
+	}
+
+	if _, exists := engine.ContainerState(started.Name); exists {
+		t.Error("expected adopted container to be removed")
+	}
+	if engine.NetworkExists("dockerlib-test") {
+		t.Error("expected network to be removed")
+	}
+}
+
+func TestAdoptByName(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	ctx := context.Background()
+
+	previous := dockerlib.NewDockerControllerFromClient(engine)
+	defer previous.ShutdownAll(ctx)
+	defer previous.CleanupNetworks(ctx)
+
+	for _, name := range []string{"dockerlib-test-api", "dockerlib-test-db", "unrelated"} {
+		err := previous.EnsureNetwork(ctx, name)
+		if err != nil {
+			t.Fatalf("unexpected error when ensuring network: %v", err)
+		}
+
+		c := dockerlib.Container{Name: name, Image: TestImage}
+		_, err = previous.Start(ctx, &c, nil)
+		if err != nil {
+			t.Fatalf("unexpected error when starting container: %v", err)
+		}
+	}
+
+	stopped := dockerlib.Container{Name: "dockerlib-test-stopped", Image: TestImage}
+	_, err := previous.Start(ctx, &stopped, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+	_ = engine.Exit(stopped.Name, 0)
+
+	controller := dockerlib.NewDockerControllerFromClient(engine)
+	adopted, err := controller.Adopt(ctx, filters.NewArgs(filters.Arg("name", "dockerlib-test")))
+	if err != nil {
+		t.Fatalf("unexpected error when adopting containers: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, c := range adopted {
+		names[c.Name] = true
+		if err := c.Readiness.Err(); err != nil {
+			t.Errorf("expected adopted container %s to be ready: %v", c.Name, err)
+		}
+	}
+	if len(names) != 2 || !names["dockerlib-test-api"] || !names["dockerlib-test-db"] {
+		t.Errorf("expected running containers matching the name to be adopted, got %v", adopted)
+	}
+
+	err = controller.ShutdownAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down containers: %v", err)
+	}
+	err = controller.CleanupNetworks(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when cleaning up networks: %v", err)
+	}
+
+	for name, want := range map[string]bool{"dockerlib-test-api": false, "dockerlib-test-db": false, "unrelated": true} {
+		if _, exists := engine.ContainerState(name); exists != want {
+			t.Errorf("expected container %s to exist: %v, got %v", name, want, exists)
+		}
+		if exists := engine.NetworkExists(name); exists != want {
+			t.Errorf("expected network %s to exist: %v, got %v", name, want, exists)
+		}
+	}
+}
+
+func TestAdoptFailure(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Fail("ContainerList", dockerlibtest.Conflict("boom"))
+
+	_, err := controller.Reconnect(context.Background())
+	if err == nil {
+		t.Fatal("expected error when containers can't be listed")
+	}
+}
+
+func TestAdoptTty(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	engine.Script("dockerlib-test-tty", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{dockerlibtest.Stdout("INFO:root:Starting"), dockerlibtest.Stderr("INFO:root:Hello!")},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// e.g. started with docker run -t
+	created, err := engine.ContainerCreate(ctx, &container.Config{Image: TestImage, Tty: true}, nil, nil, nil, "dockerlib-test-tty")
+	if err != nil {
+		t.Fatalf("unexpected error when creating container: %v", err)
+	}
+	err = engine.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	controller := dockerlib.NewDockerControllerFromClient(engine)
+	defer controller.ShutdownAll(ctx)
+
+	adopted, err := controller.Adopt(ctx, filters.NewArgs(filters.Arg("name", "dockerlib-test-tty")),
+		dockerlib.WithAdoptWaitStrategy(dockerlib.ForLog("Hello")))
+	if err != nil {
+		t.Fatalf("unexpected error when adopting containers: %v", err)
+	}
+	if len(adopted) != 1 {
+		t.Fatalf("expected a single container to be adopted, got %v", adopted)
+	}
+
+	err = adopted[0].Readiness.Wait(ctx)
+	if err != nil {
+		t.Fatalf("expected adopted container to become ready: %v", err)
+	}
+
+	// output of a TTY isn't multiplexed, so it is all reported as standard output
+	expected := []dockerlib.LogLine{
+		{Stream: dockerlib.Stdout, Text: "INFO:root:Starting"},
+		{Stream: dockerlib.Stdout, Text: "INFO:root:Hello!"},
+	}
+	if got := controller.Logs("dockerlib-test-tty"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected lines %v, got %v", expected, got)
+	}
+}
+
+func TestAdoptNetworks(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	ctx := context.Background()
+
+	previous := dockerlib.NewDockerControllerFromClient(engine)
+	err := previous.EnsureNetwork(ctx, "dockerlib-test-managed")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+	for _, name := range []string{"dockerlib-test-external", "unrelated"} {
+		_, err = engine.NetworkCreate(ctx, name, types.NetworkCreate{})
+		if err != nil {
+			t.Fatalf("unexpected error when creating network: %v", err)
+		}
+	}
+
+	c := dockerlib.Container{Name: "dockerlib-test-api", Image: TestImage, Network: []string{"dockerlib-test-external"}}
+	_, err = previous.Start(ctx, &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	// without a filter, only the networks that are managed or attached to an adopted container are adopted
+	controller := dockerlib.NewDockerControllerFromClient(engine)
+	_, err = controller.Adopt(ctx, filters.NewArgs())
+	if err != nil {
+		t.Fatalf("unexpected error when adopting containers: %v", err)
+	}
+
+	err = controller.ShutdownAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down containers: %v", err)
+	}
+	err = controller.CleanupNetworks(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when cleaning up networks: %v", err)
+	}
+
+	want := map[string]bool{
+		"dockerlib-test-managed":  false,
+		"dockerlib-test-external": false,
+		"unrelated":               true,
+		"bridge":                  true,
+		"host":                    true,
+		"none":                    true,
+	}
+	for name, exists := range want {
+		if engine.NetworkExists(name) != exists {
+			t.Errorf("expected network %s to exist: %v", name, exists)
+		}
+	}
+}
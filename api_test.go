@@ -0,0 +1,43 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/docker/api/types"
+	"testing"
+)
+
+// networkStub implements only the network calls used by EnsureNetwork, panicking on anything else.
+type networkStub struct {
+	dockerlib.DockerAPI
+	existing []types.NetworkResource
+	created  []string
+}
+
+func (s *networkStub) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	return s.existing, nil
+}
+
+func (s *networkStub) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	s.created = append(s.created, name)
+	return types.NetworkCreateResponse{ID: "id-" + name}, nil
+}
+
+func TestEnsureNetworkWithClient(t *testing.T) {
+	stub := &networkStub{existing: []types.NetworkResource{{Name: "existing", ID: "id-existing"}}}
+	controller := dockerlib.NewDockerControllerFromClient(stub)
+
+	err := controller.EnsureNetwork(context.Background(), "existing")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring existing network: %v", err)
+	}
+
+	err = controller.EnsureNetwork(context.Background(), "created")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring new network: %v", err)
+	}
+
+	if len(stub.created) != 1 || stub.created[0] != "created" {
+		t.Errorf("expected only network 'created' to be created, got %v", stub.created)
+	}
+}
@@ -0,0 +1,226 @@
+package dockerlib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubAddress is the address Docker uses for Docker Hub credentials in config.json and credential helpers.
+const dockerHubAddress = "https://index.docker.io/v1/"
+
+// errCredentialsNotFound is the message credential helpers print when they have no credentials for a registry.
+const errCredentialsNotFound = "credentials not found in native keychain"
+
+// dockerConfigFile is the subset of ~/.docker/config.json used to find registry credentials.
+type dockerConfigFile struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// credentialHelperOutput is what a docker-credential-* helper prints for `get`.
+type credentialHelperOutput struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// registryAuth resolves the credentials to pull an image with, first from credentials supplied explicitly and
+// then from the Docker CLI configuration.
+type registryAuth struct {
+	explicit  map[string]types.AuthConfig
+	configDir string
+}
+
+// registryOf returns the registry hosting the given image reference, e.g. docker.io or ghcr.io.
+func registryOf(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return normalizeRegistry(image)
+	}
+
+	return normalizeRegistry(reference.Domain(named))
+}
+
+// normalizeRegistry converts the different ways a registry is written (with or without scheme or path, and the
+// various Docker Hub aliases) to the registry's host.
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "http://")
+	registry = strings.TrimPrefix(registry, "https://")
+	if i := strings.Index(registry, "/"); i >= 0 {
+		registry = registry[:i]
+	}
+
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io", "docker.io":
+		return "docker.io"
+	default:
+		return registry
+	}
+}
+
+// serverAddress returns the address credentials for the registry are stored under by the Docker CLI.
+func serverAddress(registry string) string {
+	if registry == "docker.io" {
+		return dockerHubAddress
+	}
+
+	return registry
+}
+
+// resolve returns the credentials for the registry hosting the given image, or ok=false if there are none.
+func (a registryAuth) resolve(image string) (types.AuthConfig, bool, error) {
+	registry := registryOf(image)
+
+	for key, auth := range a.explicit {
+		if normalizeRegistry(key) == registry {
+			auth.ServerAddress = serverAddress(registry)
+			return auth, true, nil
+		}
+	}
+
+	config, err := a.readConfig()
+	if err != nil || config == nil {
+		return types.AuthConfig{}, false, err
+	}
+
+	helper := config.CredsStore
+	for key, h := range config.CredHelpers {
+		if normalizeRegistry(key) == registry {
+			helper = h
+		}
+	}
+
+	if len(helper) > 0 {
+		auth, ok, err := credentialsFromHelper(helper, serverAddress(registry))
+		if err != nil || ok {
+			return auth, ok, err
+		}
+	}
+
+	for key, auth := range config.Auths {
+		if normalizeRegistry(key) != registry {
+			continue
+		}
+
+		if len(auth.Auth) > 0 {
+			username, password, err := decodeAuth(auth.Auth)
+			if err != nil {
+				return types.AuthConfig{}, false, err
+			}
+			auth.Username, auth.Password, auth.Auth = username, password, ""
+		}
+
+		if len(auth.Username) == 0 && len(auth.IdentityToken) == 0 && len(auth.RegistryToken) == 0 {
+			continue
+		}
+
+		auth.ServerAddress = serverAddress(registry)
+		return auth, true, nil
+	}
+
+	return types.AuthConfig{}, false, nil
+}
+
+// readConfig reads config.json from the configured directory, $DOCKER_CONFIG or ~/.docker, returning nil if it
+// doesn't exist.
+func (a registryAuth) readConfig() (*dockerConfigFile, error) {
+	dir := a.configDir
+	if len(dir) == 0 {
+		dir = os.Getenv("DOCKER_CONFIG")
+	}
+	if len(dir) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config dockerConfigFile
+	err = json.Unmarshal(contents, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// decodeAuth decodes the base64 encoded "username:password" stored in config.json.
+func decodeAuth(auth string) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid auth in docker config")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// credentialsFromHelper runs docker-credential-<helper> to get the credentials for the given server address.
+func credentialsFromHelper(helper string, address string) (types.AuthConfig, bool, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(address)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, errCredentialsNotFound) {
+			return types.AuthConfig{}, false, nil
+		}
+		if len(output) > 0 {
+			err = errors.New(err.Error() + ": " + output)
+		}
+		return types.AuthConfig{}, false, err
+	}
+
+	var output credentialHelperOutput
+	err = json.Unmarshal(stdout.Bytes(), &output)
+	if err != nil {
+		return types.AuthConfig{}, false, err
+	}
+
+	auth := types.AuthConfig{ServerAddress: address}
+	if output.Username == "<token>" {
+		auth.IdentityToken = output.Secret
+	} else {
+		auth.Username = output.Username
+		auth.Password = output.Secret
+	}
+
+	return auth, true, nil
+}
+
+// encodeAuth encodes credentials the way the Docker Engine API expects in the X-Registry-Auth header.
+func encodeAuth(auth types.AuthConfig) (string, error) {
+	encoded, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
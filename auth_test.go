@@ -0,0 +1,134 @@
+package dockerlib_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeDockerConfig(t *testing.T, contents string) string {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("unable to write docker config: %v", err)
+	}
+
+	return dir
+}
+
+func TestRegistryAuthExplicit(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.RequireAuth("registry.example.com:5000", "user", "secret")
+
+	controller := dockerlib.NewDockerControllerFromClient(engine,
+		dockerlib.WithDockerConfig(t.TempDir()),
+		dockerlib.WithRegistryAuth("https://registry.example.com:5000", types.AuthConfig{Username: "user", Password: "secret"}),
+	)
+
+	err := controller.EnsureImage(context.Background(), "registry.example.com:5000/team/app:1.0")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	// credentials for one registry are never sent to another
+	engine.RequireAuth("docker.io", "user", "secret")
+	err = controller.EnsureImage(context.Background(), "private/image")
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Errorf("expected ImagePullError, got %v", err)
+	}
+}
+
+func TestRegistryAuthPullOption(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.RequireAuth("docker.io", "user", "secret")
+
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithDockerConfig(t.TempDir()))
+
+	err := controller.EnsureImage(context.Background(), "private/image",
+		dockerlib.WithPullAuth(types.AuthConfig{Username: "user", Password: "secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+}
+
+func TestRegistryAuthDockerConfig(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.RequireAuth("docker.io", "hub-user", "hub-secret")
+	engine.RequireAuth("ghcr.io", "gh-user", "gh-secret")
+
+	hub := base64.StdEncoding.EncodeToString([]byte("hub-user:hub-secret"))
+	gh := base64.StdEncoding.EncodeToString([]byte("gh-user:gh-secret"))
+	dir := writeDockerConfig(t, `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+hub+`"},
+		"ghcr.io": {"auth": "`+gh+`"}
+	}}`)
+
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithDockerConfig(dir))
+
+	for _, image := range []string{"private/image", "ghcr.io/team/app"} {
+		err := controller.EnsureImage(context.Background(), image)
+		if err != nil {
+			t.Errorf("unexpected error when ensuring image %s: %v", image, err)
+		}
+	}
+}
+
+func TestRegistryAuthCredentialHelper(t *testing.T) {
+	bin := t.TempDir()
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "ghcr.io" ]; then
+	echo '{"ServerURL": "ghcr.io", "Username": "helper-user", "Secret": "helper-secret"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+	err := os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(helper), 0700)
+	if err != nil {
+		t.Fatalf("unable to write credential helper: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	engine := dockerlibtest.NewEngine()
+	engine.RequireAuth("ghcr.io", "helper-user", "helper-secret")
+
+	dir := writeDockerConfig(t, `{"credHelpers": {"ghcr.io": "fake"}, "credsStore": "fake"}`)
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithDockerConfig(dir))
+
+	err = controller.EnsureImage(context.Background(), "ghcr.io/team/app")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	// the helper has no credentials for docker.io, so the image is pulled anonymously
+	err = controller.EnsureImage(context.Background(), "alpine")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring public image: %v", err)
+	}
+}
+
+func TestRegistryAuthMissingCredentialHelper(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	dir := writeDockerConfig(t, `{"credsStore": "does-not-exist"}`)
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithDockerConfig(dir))
+
+	err := controller.EnsureImage(context.Background(), "alpine")
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Errorf("expected ImagePullError, got %v", err)
+	}
+
+	if engine.Calls("ImagePull") != 0 {
+		t.Error("expected image not to be pulled")
+	}
+}
@@ -0,0 +1,270 @@
+package dockerlib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/fileutils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// buildOutputSize is the number of lines of build output kept for an ImageBuildError.
+const buildOutputSize = 20
+
+// BuildSpec describes an image to build with DockerController.BuildImage.
+type BuildSpec struct {
+	// ContextDir is the directory sent to Docker as the build context. Files matching .dockerignore are excluded.
+	ContextDir string
+	// Files are added to the build context, replacing files with the same path in ContextDir. Together with
+	// Dockerfile they can be used to build an image without a context directory.
+	Files map[string][]byte
+	// Dockerfile is the path of the Dockerfile within the build context. Defaults to Dockerfile.
+	Dockerfile string
+	BuildArgs  map[string]string
+	// Target is the stage of a multi-stage Dockerfile to build. Defaults to the last stage.
+	Target string
+	// Labels are added to the image, along with ManagedLabel.
+	Labels  map[string]string
+	Tags    []string
+	NoCache bool
+	// Progress, if set, is called with every line of build output and every layer pulled for the build.
+	Progress func(EnsureImageProgress)
+}
+
+// BuildImage builds an image from the BuildSpec, returning its ID. It returns an ImageBuildError if the build
+// fails, including the step that failed and the last lines of output, or if the output is cut off before the ID of
+// the image is reported.
+func (controller *DockerController) BuildImage(ctx context.Context, spec BuildSpec) (string, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	buildContext, err := buildContextArchive(spec)
+	if err != nil {
+		controller.log().Errorf("Unable to create build context: %v", err)
+		return "", DockerError{"unable to create build context", err}
+	}
+
+	options := types.ImageBuildOptions{
+		Tags:       spec.Tags,
+		NoCache:    spec.NoCache,
+		Remove:     true,
+		Dockerfile: spec.Dockerfile,
+		Target:     spec.Target,
+		Labels:     map[string]string{ManagedLabel: "true"},
+		BuildArgs:  make(map[string]*string, len(spec.BuildArgs)),
+	}
+	for key, value := range spec.Labels {
+		options.Labels[key] = value
+	}
+	for key, value := range spec.BuildArgs {
+		value := value
+		options.BuildArgs[key] = &value
+	}
+
+	controller.log().Infof("Building image %v", spec.Tags)
+	resp, err := controller.cli.ImageBuild(ctx, buildContext, options)
+	if err != nil {
+		controller.log().Errorf("Unable to build image %v: %v", spec.Tags, err)
+		return "", ImageBuildError{Message: err.Error(), baseError: err}
+	}
+	defer resp.Body.Close()
+
+	var id, step string
+	output := NewLogBuffer(buildOutputSize)
+	tracker := newPullTracker()
+	msg, code, failed, err := controller.readProgress(resp.Body, func(progress EnsureImageProgress) {
+		if progress.Aux != nil && len(progress.Aux.ID) > 0 {
+			id = progress.Aux.ID
+			return
+		}
+
+		if len(progress.Stream) > 0 {
+			for _, line := range strings.Split(strings.TrimRight(progress.Stream, "\n"), "\n") {
+				switch {
+				case strings.HasPrefix(line, "Step "):
+					step = line
+				case strings.HasPrefix(line, "Successfully built ") && len(id) == 0:
+					id = strings.TrimPrefix(line, "Successfully built ")
+				}
+				output.Accept(LogLine{Stream: Stdout, Text: line})
+			}
+		} else {
+			tracker.track(&progress)
+		}
+
+		controller.log().Info(progress)
+		if spec.Progress != nil {
+			spec.Progress(progress)
+		}
+	})
+	if failed {
+		controller.log().Errorf("Unable to build image %v at %s: %s", spec.Tags, step, msg)
+		return "", ImageBuildError{Step: step, Message: msg, Code: code, Output: output.Lines()}
+	}
+	if err != nil {
+		controller.log().Errorf("Unable to read progress of building image %v: %v", spec.Tags, err)
+		msg := "unable to read build progress: " + err.Error()
+		return "", ImageBuildError{Step: step, Message: msg, Output: output.Lines(), baseError: err}
+	}
+
+	if err := ctx.Err(); err != nil {
+		controller.log().Errorf("Unable to build image %v: %v", spec.Tags, err)
+		return "", ImageBuildError{Step: step, Message: err.Error(), Output: output.Lines(), baseError: err}
+	}
+
+	if len(id) == 0 {
+		controller.log().Errorf("Build of image %v didn't report the ID of the image", spec.Tags)
+		return "", ImageBuildError{Step: step, Message: "build didn't report the ID of the image", Output: output.Lines()}
+	}
+
+	return id, nil
+}
+
+// buildContextArchive creates the tar archive of the build context sent to Docker.
+func buildContextArchive(spec BuildSpec) (io.Reader, error) {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+
+	if len(spec.ContextDir) > 0 {
+		err := addContextDir(writer, spec.ContextDir, spec.Dockerfile, spec.Files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(spec.Files))
+	for name := range spec.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		contents := spec.Files[name]
+		header := &tar.Header{Name: filepath.ToSlash(name), Mode: 0644, Size: int64(len(contents))}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(contents); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return &buffer, nil
+}
+
+// addContextDir adds the files in dir to the archive, except those excluded by .dockerignore or replaced by files.
+func addContextDir(writer *tar.Writer, dir string, dockerfile string, files map[string][]byte) error {
+	excludes, err := readDockerignore(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(dockerfile) == 0 {
+		dockerfile = "Dockerfile"
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if _, replaced := files[name]; replaced {
+			return nil
+		}
+
+		// like the Docker CLI, the Dockerfile and .dockerignore are always sent
+		if name != filepath.ToSlash(dockerfile) && name != ".dockerignore" && excludes != nil {
+			excluded, err := excludes.Matches(rel)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				if info.IsDir() && !excludes.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(writer, file)
+		return err
+	})
+}
+
+// readDockerignore reads the exclusion patterns in the .dockerignore file of dir, returning nil if there isn't one.
+func readDockerignore(dir string) (*fileutils.PatternMatcher, error) {
+	file, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if len(pattern) == 0 || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		exception := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimSpace(strings.TrimPrefix(pattern, "!"))
+		pattern = filepath.Clean(filepath.FromSlash(strings.TrimPrefix(pattern, "/")))
+		if exception {
+			pattern = "!" + pattern
+		}
+		patterns = append(patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return fileutils.NewPatternMatcher(patterns)
+}
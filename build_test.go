@@ -0,0 +1,224 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBuildImageFromFiles(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	var output []string
+	id, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files: map[string][]byte{
+			"Dockerfile": []byte("FROM alpine\nARG VERSION\nCOPY app.sh /app.sh\nRUN echo built\nCMD [\"/app.sh\"]\n"),
+			"app.sh":     []byte("#!/bin/sh\n"),
+		},
+		BuildArgs: map[string]string{"VERSION": "1.0"},
+		Labels:    map[string]string{"team": "platform"},
+		Tags:      []string{"example/app:1.0"},
+		Progress: func(p dockerlib.EnsureImageProgress) {
+			output = append(output, p.String())
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when building image: %v", err)
+	}
+
+	if !strings.HasPrefix(id, "sha256:") {
+		t.Errorf("expected image ID, got %q", id)
+	}
+
+	if !engine.HasImage("example/app:1.0") || !engine.HasImage(id) {
+		t.Errorf("expected image %s to be tagged example/app:1.0", id)
+	}
+
+	if !contains(output, "Step 4/5 : RUN echo built") || !contains(output, "built") {
+		t.Errorf("expected build output to be streamed, got %v", output)
+	}
+
+	builds := engine.Builds()
+	if len(builds) != 1 {
+		t.Fatalf("expected 1 build, got %d", len(builds))
+	}
+
+	options := builds[0].Options
+	if *options.BuildArgs["VERSION"] != "1.0" || options.Labels["team"] != "platform" {
+		t.Errorf("unexpected build options: %+v", options)
+	}
+}
+
+func TestBuildImageFromContextDir(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"build/Dockerfile": "FROM alpine\nCOPY . /src\n",
+		"main.go":          "package main\n",
+		"config.yaml":      "replaced: false\n",
+		"secret.env":       "TOKEN=abc\n",
+		"logs/out.log":     "log\n",
+		".dockerignore":    "*.env\nlogs\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		ContextDir: dir,
+		Dockerfile: "build/Dockerfile",
+		Files:      map[string][]byte{"config.yaml": []byte("replaced: true\n")},
+		Tags:       []string{"example/app"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when building image: %v", err)
+	}
+
+	sent := engine.Builds()[0].Files
+	var names []string
+	for name := range sent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expected := []string{".dockerignore", "build/Dockerfile", "config.yaml", "main.go"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected build context %v, got %v", expected, names)
+	}
+
+	if string(sent["config.yaml"]) != "replaced: true\n" {
+		t.Errorf("expected in-memory file to replace file in context dir, got %q", sent["config.yaml"])
+	}
+}
+
+func TestBuildImageTarget(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	_, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files: map[string][]byte{
+			"Dockerfile": []byte("FROM alpine AS build\nRUN echo compile\n\nFROM alpine\nRUN exit 1\n"),
+		},
+		Target: "build",
+		Tags:   []string{"example/build"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when building target: %v", err)
+	}
+
+	if !engine.HasImage("example/build") {
+		t.Error("expected target stage to be built")
+	}
+}
+
+func TestBuildImageFailure(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	_, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files: map[string][]byte{
+			"Dockerfile": []byte("FROM alpine\nRUN echo compiling\nRUN make || exit 3\nCMD [\"app\"]\n"),
+		},
+		Tags: []string{"example/broken"},
+	})
+
+	var buildErr dockerlib.ImageBuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected ImageBuildError, got %v", err)
+	}
+
+	if buildErr.Step != "Step 3/4 : RUN make || exit 3" || buildErr.Code != 3 {
+		t.Errorf("unexpected build error: %+v", buildErr)
+	}
+
+	if len(buildErr.Output) == 0 || !strings.Contains(err.Error(), "compiling") {
+		t.Errorf("expected build output in error, got %v", err)
+	}
+
+	if engine.HasImage("example/broken") {
+		t.Error("expected failed build not to be tagged")
+	}
+}
+
+// buildStreamEngine replaces the output of builds with the output of stream.
+type buildStreamEngine struct {
+	*dockerlibtest.Engine
+	stream func(io.Reader) io.Reader
+}
+
+func (e buildStreamEngine) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	resp, err := e.Engine.ImageBuild(ctx, buildContext, options)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Body = ioutil.NopCloser(e.stream(resp.Body))
+	return resp, nil
+}
+
+func TestBuildImageTruncatedStream(t *testing.T) {
+	tests := map[string]func(io.Reader) io.Reader{
+		"cut off": func(r io.Reader) io.Reader {
+			return &truncatedReader{r, 100, errors.New("connection reset by peer")}
+		},
+		"without ID": func(io.Reader) io.Reader {
+			return strings.NewReader(`{"stream":"Step 1/1 : FROM alpine\n"}` + "\n")
+		},
+	}
+
+	for name, stream := range tests {
+		t.Run(name, func(t *testing.T) {
+			engine := dockerlibtest.NewEngine()
+			controller := dockerlib.NewDockerControllerFromClient(buildStreamEngine{engine, stream})
+
+			id, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+				Files: map[string][]byte{"Dockerfile": []byte("FROM alpine\n")},
+			})
+
+			var buildErr dockerlib.ImageBuildError
+			if !errors.As(err, &buildErr) {
+				t.Fatalf("expected ImageBuildError, got %q, %v", id, err)
+			}
+			if buildErr.Step != "Step 1/1 : FROM alpine" || len(buildErr.Output) == 0 {
+				t.Errorf("expected step and output in build error, got %+v", buildErr)
+			}
+		})
+	}
+}
+
+func TestBuildImageMissingSource(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	_, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files: map[string][]byte{"Dockerfile": []byte("FROM alpine\nCOPY missing.txt /\n")},
+	})
+
+	var buildErr dockerlib.ImageBuildError
+	if !errors.As(err, &buildErr) || buildErr.Step != "Step 2/2 : COPY missing.txt /" {
+		t.Errorf("expected ImageBuildError for COPY, got %v", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,177 @@
+package dockerlib_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+const parallelism = 16
+
+func TestFakeConcurrentEnsureNetwork(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, parallelism)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- controller.EnsureNetwork(ctx, "dockerlib")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error when ensuring network: %v", err)
+		}
+	}
+
+	networks, err := engine.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		t.Fatalf("unable to list networks: %v", err)
+	}
+
+	count := 0
+	for _, nw := range networks {
+		if nw.Name == "dockerlib" {
+			count++
+		}
+	}
+
+	if count != 1 {
+		t.Errorf("expected exactly 1 network named dockerlib, got %d", count)
+	}
+
+	err = controller.CleanupNetworks(ctx)
+	if err != nil {
+		t.Errorf("unexpected error when cleaning up networks: %v", err)
+	}
+
+	if engine.NetworkExists("dockerlib") {
+		t.Error("expected network dockerlib to be removed")
+	}
+}
+
+func TestFakeConcurrentStartAndShutdown(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script(TestImage, dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{dockerlibtest.Stdout("ready")},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*parallelism)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			err := controller.EnsureNetwork(ctx, "dockerlib")
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			c := dockerlib.Container{
+				Name:    fmt.Sprintf("dockerlib-test-%d", i),
+				Image:   TestImage,
+				Network: []string{"dockerlib"},
+			}
+
+			ready, err := controller.Start(ctx, &c, dockerlib.ForLog("ready"))
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			err = ready.Wait(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			_ = controller.Logs(c.Name)
+
+			// leave every other container running for ShutdownAll
+			if i%2 == 0 {
+				return
+			}
+
+			err = controller.Shutdown(ctx, c)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			err = controller.Remove(ctx, c)
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	err := controller.ShutdownAll(ctx)
+	if err != nil {
+		errs <- err
+	}
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < parallelism; i++ {
+		name := fmt.Sprintf("dockerlib-test-%d", i)
+		if _, exists := engine.ContainerState(name); exists {
+			t.Errorf("expected container %s to be removed", name)
+		}
+
+		if len(controller.Logs(name)) != 1 {
+			t.Errorf("expected logs of container %s to be retained, got %v", name, controller.Logs(name))
+		}
+	}
+}
+
+func TestFakeConcurrentShutdownAll(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i := 0; i < parallelism; i++ {
+		c := dockerlib.Container{Name: fmt.Sprintf("dockerlib-test-%d", i), Image: TestImage}
+		_, err := controller.Start(ctx, &c, nil)
+		if err != nil {
+			t.Fatalf("unexpected error when starting container: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// concurrent calls may race to stop the same container, so only the end state is checked
+			_ = controller.ShutdownAll(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < parallelism; i++ {
+		name := fmt.Sprintf("dockerlib-test-%d", i)
+		if state, exists := engine.ContainerState(name); exists && state == "running" {
+			t.Errorf("expected container %s to be shut down", name)
+		}
+	}
+}
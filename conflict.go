@@ -0,0 +1,118 @@
+package dockerlib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// NameConflictPolicy determines what Start does when a container with the name of the Container already exists.
+type NameConflictPolicy string
+
+const (
+	// NameConflictFail fails with a NameConflictError, which is what Start does unless another policy is given.
+	NameConflictFail NameConflictPolicy = "fail"
+
+	// NameConflictReplace removes the existing container, e.g. one left behind by a previous run, and creates the
+	// container in its place. Only containers created by dockerlib (see ManagedLabel) are removed; otherwise Start
+	// fails with a NameConflictError.
+	NameConflictReplace NameConflictPolicy = "replace"
+
+	// NameConflictSuffix creates the container with a unique name instead, made of its name and a random suffix
+	// (e.g. postgres-1a2b3c4d).
+	NameConflictSuffix NameConflictPolicy = "suffix"
+)
+
+// suffixAttempts is how many suffixed names NameConflictSuffix tries before giving up.
+const suffixAttempts = 3
+
+// NameConflictError indicates that a container couldn't be created because its name is already in use.
+type NameConflictError struct {
+	Name      string
+	baseError error
+}
+
+func (e NameConflictError) Error() string {
+	return "unable to create container " + e.Name + ": " + e.baseError.Error()
+}
+
+func (e NameConflictError) Unwrap() error {
+	return e.baseError
+}
+
+// validate returns an error if the policy is unknown.
+func (p NameConflictPolicy) validate() error {
+	switch p {
+	case "", NameConflictFail, NameConflictReplace, NameConflictSuffix:
+		return nil
+	default:
+		return errors.New("unknown name conflict policy " + string(p))
+	}
+}
+
+// create creates the container for c, resolving a conflict with the name of an existing container according to
+// the NameConflict policy of c. The name the container was created with is stored in c.
+func (controller *DockerController) create(ctx context.Context, c *Container, config *container.Config, hostConfig *container.HostConfig) (container.ContainerCreateCreatedBody, error) {
+	resp, err := controller.cli.ContainerCreate(ctx, config, hostConfig, nil, c.Platform, c.Name)
+	if errdefs.IsConflict(err) {
+		switch c.NameConflict {
+		case NameConflictReplace:
+			resp, err = controller.replace(ctx, c, config, hostConfig, err)
+		case NameConflictSuffix:
+			for i := 0; i < suffixAttempts && errdefs.IsConflict(err); i++ {
+				name := c.Name + "-" + nameSuffix()
+				resp, err = controller.cli.ContainerCreate(ctx, config, hostConfig, nil, c.Platform, name)
+				if err == nil {
+					controller.log().Infof("Name of container %s is in use, created it as %s", c.Name, name)
+					c.Name = name
+				}
+			}
+		}
+	}
+
+	var conflict NameConflictError
+	var containerErr ContainerError
+	switch {
+	case err == nil:
+		return resp, nil
+	case errors.As(err, &conflict), errors.As(err, &containerErr):
+		// already explained by replace
+		return resp, err
+	case errdefs.IsConflict(err):
+		controller.log().Errorf("Unable to create container %s, its name is in use: %v", c, err)
+		return resp, NameConflictError{Name: c.Name, baseError: err}
+	default:
+		controller.log().Errorf("Unable to create container %s: %v", c, err)
+		return resp, ContainerError{"unable to create container", c.Name, err}
+	}
+}
+
+// replace removes the existing container with the name of c, provided it was created by dockerlib, and creates the
+// container for c in its place.
+func (controller *DockerController) replace(ctx context.Context, c *Container, config *container.Config, hostConfig *container.HostConfig, conflict error) (container.ContainerCreateCreatedBody, error) {
+	info, err := controller.cli.ContainerInspect(ctx, c.Name)
+	if err != nil || info.Config == nil || info.Config.Labels[ManagedLabel] != "true" {
+		controller.log().Errorf("Not replacing container %s, which wasn't created by dockerlib", c.Name)
+		return container.ContainerCreateCreatedBody{}, NameConflictError{Name: c.Name, baseError: conflict}
+	}
+
+	controller.log().Infof("Replacing existing container %s (%s)", c.Name, info.ID)
+	err = controller.cli.ContainerRemove(ctx, info.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+	if err != nil {
+		controller.log().Errorf("Unable to remove container %s: %v", c.Name, err)
+		return container.ContainerCreateCreatedBody{}, ContainerError{"unable to replace container", c.Name, err}
+	}
+
+	return controller.cli.ContainerCreate(ctx, config, hostConfig, nil, c.Platform, c.Name)
+}
+
+// nameSuffix returns a random suffix for the name of a container.
+func nameSuffix() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,103 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/docker/api/types/container"
+	"strings"
+	"testing"
+)
+
+func TestNameConflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  dockerlib.NameConflictPolicy
+		managed bool
+		replace bool
+		suffix  bool
+	}{
+		{name: "default", managed: true},
+		{name: "fail", policy: dockerlib.NameConflictFail, managed: true},
+		{name: "replace", policy: dockerlib.NameConflictReplace, managed: true, replace: true},
+		{name: "replace unmanaged", policy: dockerlib.NameConflictReplace},
+		{name: "suffix", policy: dockerlib.NameConflictSuffix, managed: true, suffix: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller, engine := newFakeController(t)
+			ctx := context.Background()
+
+			var existing string
+			if test.managed {
+				stale := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+				_, err := dockerlib.NewDockerControllerFromClient(engine).Start(ctx, &stale, nil)
+				if err != nil {
+					t.Fatalf("unexpected error when starting container: %v", err)
+				}
+				existing = stale.ID
+			} else {
+				resp, err := engine.ContainerCreate(ctx, &container.Config{Image: TestImage}, &container.HostConfig{}, nil, nil, "dockerlib-test")
+				if err != nil {
+					t.Fatalf("unexpected error when creating container: %v", err)
+				}
+				existing = resp.ID
+			}
+
+			c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage, NameConflict: test.policy}
+			_, err := controller.Start(ctx, &c, nil)
+
+			var conflict dockerlib.NameConflictError
+			switch {
+			case test.replace:
+				if err != nil {
+					t.Fatalf("unexpected error when replacing container: %v", err)
+				}
+				if _, err := engine.ContainerInspect(ctx, existing); err == nil {
+					t.Error("expected existing container to be removed")
+				}
+				if c.Name != "dockerlib-test" || c.ID == existing {
+					t.Errorf("expected container to be created in place of the existing one, got %v", c)
+				}
+			case test.suffix:
+				if err != nil {
+					t.Fatalf("unexpected error when suffixing container name: %v", err)
+				}
+				if !strings.HasPrefix(c.Name, "dockerlib-test-") || len(c.Name) != len("dockerlib-test-")+8 {
+					t.Errorf("expected suffixed name, got %s", c.Name)
+				}
+				if state, _ := engine.ContainerState(c.Name); state != "running" {
+					t.Errorf("expected container %s to be running, got %s", c.Name, state)
+				}
+				if state, _ := engine.ContainerState(existing); state != "running" {
+					t.Errorf("expected existing container to be left alone, got %s", state)
+				}
+			default:
+				if !errors.As(err, &conflict) || conflict.Name != "dockerlib-test" {
+					t.Fatalf("expected name conflict error, got %v", err)
+				}
+				if !strings.Contains(err.Error(), "unable to create container dockerlib-test") {
+					t.Errorf("unexpected error message %q", err)
+				}
+				if _, err := engine.ContainerInspect(ctx, existing); err != nil {
+					t.Error("expected existing container to be left alone")
+				}
+			}
+		})
+	}
+}
+
+func TestNameConflictInvalidPolicy(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage, NameConflict: "rename"}
+	_, err := controller.Start(context.Background(), &c, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown name conflict policy rename") {
+		t.Fatalf("expected invalid policy error, got %v", err)
+	}
+
+	if engine.Calls("ContainerCreate") != 0 {
+		t.Error("expected container not to be created")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/go-connections/nat"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Container represents a simplified interface for starting a Docker container
@@ -16,6 +17,41 @@ type Container struct {
 	Command     []string
 	Environment []string
 	Network     []string
+
+	// LogConsumers receive every line of output of the container, in order, from a single goroutine.
+	LogConsumers []LogConsumer
+	// LogParser, if set, decodes lines of output so they are logged with the level and fields of the service
+	// running in the container instead of as plain text at the info level.
+	LogParser LogParser
+	// LogBufferSize is the number of lines of output retained for DockerController.Logs. Defaults to
+	// DefaultLogBufferSize.
+	LogBufferSize int
+	// PullPolicy, if set, ensures the image using the policy before the container is created. By default the
+	// image must already be available.
+	PullPolicy PullPolicy
+	// Platform, if set, is the platform (e.g. linux/arm64) the container runs on. The image is pulled for this
+	// platform when PullPolicy is set, and the container isn't started unless the local image is for it. Requires
+	// Docker API version 1.41 or later.
+	Platform *specs.Platform
+	// HealthCheck, if set, configures how Docker checks the health of the container, overriding the HEALTHCHECK of
+	// its image. See ForHealthy and DockerController.Health.
+	HealthCheck *HealthCheck
+	// Resources limits the memory, CPU and other resources the container can use. By default it is unlimited.
+	Resources Resources
+	// Labels are added to the container and to the volumes Docker creates for its mounts, along with the labels the
+	// controller adds to every resource it creates (ManagedLabel and SessionLabel).
+	Labels map[string]string
+	// Reuse, if set, starts (or keeps running) an existing container with the same name instead of creating one,
+	// provided it was also started with Reuse and the same image, environment, ports, mounts, command and
+	// networks (see ConfigHashLabel). Otherwise the existing container is removed and created again. Reusable
+	// containers aren't part of the controller's session, so they outlive it: they are left running by
+	// ShutdownAll, and neither they nor the volumes created for their mounts are removed by the reaper. The networks
+	// they are attached to are kept by CleanupNetworks, and if one is removed anyway (e.g. by the reaper), the
+	// container is recreated. When a stopped container is started again, only the output of the new run is followed.
+	Reuse bool
+	// NameConflict determines what Start does when a container with Name already exists. By default it fails
+	// with a NameConflictError. With NameConflictSuffix, Name is set to the name the container was created with.
+	NameConflict NameConflictPolicy
 }
 
 // Returns a simplified string representation
@@ -2,93 +2,252 @@ package dockerlib
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"github.com/containerd/containerd/platforms"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"go.uber.org/zap"
 	"strings"
+	"sync"
 	"time"
 )
 
 // DockerController is a concrete type that can be used to control Docker containers
-// using its SDK.
+// using its SDK. It is safe for concurrent use by multiple goroutines.
 type DockerController struct {
-	cli      *client.Client
-	running  map[string]Container
-	networks map[string]string
+	cli     DockerAPI
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+	auth    registryAuth
+	// imageCache is a directory of tarballs to load images from when they can't be pulled.
+	imageCache string
+	// session is the value of SessionLabel on every container, network and volume the controller creates.
+	session string
+
+	// mu guards the bookkeeping maps below; it is never held while calling the Docker API.
+	mu      sync.Mutex
+	running map[string]Container
+	logs    map[string]*LogBuffer
+	// adopted holds the names of the networks taken over by Adopt, by ID.
+	adopted map[string]string
+
+	// networkMu serializes EnsureNetwork so that concurrent callers cannot both observe a missing network
+	// and create it twice.
+	networkMu sync.Mutex
 }
 
-// NewDockerController is a helper method to create a new instance of a DockerController.
-func NewDockerController() (*DockerController, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+// NewDockerController is a helper method to create a new instance of a DockerController. Without any options
+// the Docker client is configured from the environment (DOCKER_HOST, DOCKER_TLS_VERIFY, etc.).
+func NewDockerController(opts ...Option) (*DockerController, error) {
+	var options controllerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	cli, err := client.NewClientWithOpts(options.clientOpts()...)
 	if err != nil {
-		logger.Errorf("Unable to create Docker client: %v", err)
+		log := options.logger
+		if log == nil {
+			log = logger
+		}
+		log.Errorf("Unable to create Docker client: %v", err)
 		return nil, DockerError{"unable to create Docker client", err}
 	}
 
+	options.client = cli
+	return newDockerController(options), nil
+}
+
+// NewDockerControllerFromClient creates a new instance of a DockerController that uses the provided DockerAPI
+// implementation instead of connecting to the Docker daemon from the environment. Options that configure the Docker
+// client (host, API version, TLS, HTTP client) don't apply to it.
+func NewDockerControllerFromClient(cli DockerAPI, opts ...Option) *DockerController {
+	var options controllerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	options.client = cli
+	return newDockerController(options)
+}
+
+func newDockerController(options controllerOptions) *DockerController {
+	if len(options.session) == 0 {
+		options.session = newSessionID()
+	}
+
 	return &DockerController{
-		cli:      cli,
-		running:  make(map[string]Container, 5),
-		networks: make(map[string]string, 5),
-	}, nil
+		cli:        options.client,
+		timeout:    options.timeout,
+		logger:     options.logger,
+		auth:       options.auth,
+		imageCache: options.imageCache,
+		session:    options.session,
+		running:    make(map[string]Container, 5),
+		logs:       make(map[string]*LogBuffer, 5),
+		adopted:    make(map[string]string),
+	}
 }
 
-// EnsureImage is a helper method to pull the specified image to the local machine running Docker.
-func (controller *DockerController) EnsureImage(ctx context.Context, image string) error {
-	reader, err := controller.cli.ImagePull(ctx, image, types.ImagePullOptions{})
+// EnsureImage is a helper method to pull the specified image to the local machine running Docker. By default the
+// image is always pulled for the platform of the Docker host, which can be changed using WithPullPolicy and
+// WithPullPlatform. It returns an ImagePullError if Docker is unable to pull the image, including when the failure
+// is only reported part-way through the pull or the pull is cut off, unless the image can be loaded from the cache
+// configured with WithImageCache instead.
+func (controller *DockerController) EnsureImage(ctx context.Context, image string, opts ...PullOption) error {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	options := newPullOptions(opts)
+
+	switch options.policy {
+	case PullAlways, PullIfNotPresent, PullNever:
+	default:
+		return ImagePullError{Image: image, Message: "unknown pull policy " + string(options.policy)}
+	}
+
+	if options.policy != PullAlways {
+		present, err := controller.imagePresent(ctx, image, options.platform)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case present:
+			controller.log().Infof("Image %s is present, not pulling with policy %s", image, options.policy)
+			return nil
+		case options.policy == PullNever:
+			msg := "image is not present locally"
+			if options.platform != nil {
+				msg += " for platform " + platforms.Format(platforms.Normalize(*options.platform))
+			}
+			controller.log().Errorf("Image %s: %s and pull policy is %s", image, msg, options.policy)
+			return ImagePullError{Image: image, Message: msg + " and pull policy is " + string(options.policy)}
+		}
+	}
+
+	pullOptions, err := controller.imagePullOptions(image, options)
+	if err != nil {
+		return err
+	}
+
+	reader, err := controller.cli.ImagePull(ctx, image, pullOptions)
 	if err != nil {
-		logger.Errorf("Unable to ensure image %s exists: %v", image, err)
-		return DockerError{"unable to ensure image " + image + " exists", err}
+		controller.log().Errorf("Unable to ensure image %s exists: %v", image, err)
+		return controller.pullFallback(ctx, ImagePullError{Image: image, Message: err.Error(), baseError: err})
 	}
 
 	defer reader.Close()
-	lines := ReadLinesAsBytes(reader)
-	for line := range lines {
-		var progress EnsureImageProgress
-		err := json.Unmarshal(line, &progress)
-		if err != nil {
-			logger.Errorw("Unable to unmarshall bytes", "line", string(line))
-			continue
+	tracker := newPullTracker()
+	msg, code, failed, err := controller.readProgress(reader, func(progress EnsureImageProgress) {
+		tracker.track(&progress)
+		controller.log().Info(progress)
+		if options.progress != nil {
+			options.progress(progress)
 		}
+	})
+	if failed {
+		controller.log().Errorf("Unable to pull image %s: %s", image, msg)
+		return controller.pullFallback(ctx, ImagePullError{Image: image, Message: msg, Code: code})
+	}
+	if err != nil {
+		controller.log().Errorf("Unable to read progress of pulling image %s: %v", image, err)
+		msg := "unable to read pull progress: " + err.Error()
+		return controller.pullFallback(ctx, ImagePullError{Image: image, Message: msg, baseError: err})
+	}
 
-		logger.Info(progress)
+	if err := ctx.Err(); err != nil {
+		controller.log().Errorf("Unable to pull image %s: %v", image, err)
+		return ImagePullError{Image: image, Message: err.Error(), baseError: err}
 	}
 
 	return nil
 }
 
-// EnsureNetwork Creates a bridge network for the given name if it doesn't already exist.
-func (controller *DockerController) EnsureNetwork(ctx context.Context, name string) error {
-	logger.Info("Listing networks")
+// EnsureNetwork Creates a bridge network for the given name if it doesn't already exist. Networks it creates are
+// labelled with SessionLabel, and removed by CleanupNetworks.
+func (controller *DockerController) EnsureNetwork(ctx context.Context, name string, opts ...NetworkOption) error {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	controller.networkMu.Lock()
+	defer controller.networkMu.Unlock()
+
+	controller.log().Info("Listing networks")
 	networks, err := controller.cli.NetworkList(ctx, types.NetworkListOptions{})
 	if err != nil {
-		logger.Errorf("Unable to list networks: %v", err)
+		controller.log().Errorf("Unable to list networks: %v", err)
 		return DockerError{"unable to list networks", err}
 	}
 
 	for _, network := range networks {
 		if network.Name == name {
-			logger.Infof("Network %s already exists, returning", name)
+			controller.log().Infof("Network %s already exists, returning", name)
 			return nil
 		}
 	}
 
-	network, err := controller.cli.NetworkCreate(ctx, name, types.NetworkCreate{})
+	options := newNetworkOptions(opts)
+	_, err = controller.cli.NetworkCreate(ctx, name, types.NetworkCreate{Labels: controller.labels(options.labels)})
 	if err != nil {
-		logger.Errorf("Unable to create network %s: %v", name, err)
+		controller.log().Errorf("Unable to create network %s: %v", name, err)
 		return NetworkError{"unable to create network", name, err}
 	}
 
-	controller.networks[name] = network.ID
 	return nil
 }
 
 // Start is the method used to Start a Docker container using the specified Container c. It also automatically
-// follows logs and creates a channel that is used to indicate when a running container is ready according to the
-// provided ready string.
-func (controller *DockerController) Start(ctx context.Context, c *Container, ready string) (chan bool, error) {
-	logger := logger.Named(c.Name)
+// follows logs and returns a Readiness that resolves once the running container is ready according to the
+// provided WaitStrategy, or with an error explaining why it never will be. If ready is nil, the container is
+// considered ready as soon as it has started. If the name of c is already in use, Start resolves the conflict
+// according to the NameConflict policy of c, and c holds the name the container was created with afterwards.
+func (controller *DockerController) Start(ctx context.Context, c *Container, ready WaitStrategy) (*Readiness, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	logger := controller.log().Named(c.Name)
+
+	if c.HealthCheck != nil {
+		err := c.HealthCheck.validate()
+		if err != nil {
+			logger.Errorf("Invalid health check: %v", err)
+			return nil, ContainerError{"invalid health check for container", c.Name, err}
+		}
+	}
+
+	err := c.Resources.validate()
+	if err != nil {
+		logger.Errorf("Invalid resources: %v", err)
+		return nil, ContainerError{"invalid resources for container", c.Name, err}
+	}
+
+	err = c.NameConflict.validate()
+	if err != nil {
+		logger.Errorf("Invalid name conflict policy: %v", err)
+		return nil, ContainerError{"invalid name conflict policy for container", c.Name, err}
+	}
+
+	if len(c.PullPolicy) > 0 {
+		pullOpts := []PullOption{WithPullPolicy(c.PullPolicy)}
+		if c.Platform != nil {
+			pullOpts = append(pullOpts, WithPullPlatform(*c.Platform))
+		}
+
+		err := controller.EnsureImage(ctx, c.Image, pullOpts...)
+		if err != nil {
+			return nil, ContainerError{"unable to ensure image for container", c.Name, err}
+		}
+	}
+
+	if c.Platform != nil {
+		err := controller.checkPlatform(ctx, c.Image, *c.Platform)
+		if err != nil {
+			return nil, ContainerError{"unable to use image for container", c.Name, err}
+		}
+	}
 
 	portSet, portMap, err := c.PortBindings()
 	if err != nil {
@@ -96,9 +255,37 @@ func (controller *DockerController) Start(ctx context.Context, c *Container, rea
 		return nil, ContainerError{"unable to get port bindings for container", c.Name, err}
 	}
 
+	labels := controller.labels(c.Labels)
+	mounts := controller.labelMounts(c.Mounts, c.Labels)
+
+	if c.Reuse {
+		hash, err := controller.configHash(ctx, *c)
+		if err != nil {
+			return nil, err
+		}
+
+		reused, follow, err := controller.reuse(ctx, c, hash)
+		if err != nil {
+			return nil, err
+		}
+		if reused {
+			return controller.track(*c, ready, follow), nil
+		}
+
+		// reusable containers and their volumes aren't part of the session, so that they outlive it
+		delete(labels, SessionLabel)
+		labels[ConfigHashLabel] = hash
+		for _, m := range mounts {
+			if m.VolumeOptions != nil {
+				delete(m.VolumeOptions.Labels, SessionLabel)
+			}
+		}
+	}
+
 	hostConfig := container.HostConfig{}
-	hostConfig.Mounts = c.Mounts
+	hostConfig.Mounts = mounts
 	hostConfig.PortBindings = portMap
+	c.Resources.apply(&hostConfig)
 
 	containerConfig := container.Config{
 		ExposedPorts: portSet,
@@ -106,12 +293,15 @@ func (controller *DockerController) Start(ctx context.Context, c *Container, rea
 		Cmd:          c.Command,
 		Image:        c.Image,
 		Env:          c.Environment,
+		Labels:       labels,
+	}
+	if c.HealthCheck != nil {
+		containerConfig.Healthcheck = c.HealthCheck.config()
 	}
 
-	resp, err := controller.cli.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, c.Name)
+	resp, err := controller.create(ctx, c, &containerConfig, &hostConfig)
 	if err != nil {
-		logger.Errorf("Unable to create container %s: %v", c, err)
-		return nil, ContainerError{"unable to create container", c.Name, err}
+		return nil, err
 	}
 
 	err = controller.cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
@@ -126,12 +316,52 @@ func (controller *DockerController) Start(ctx context.Context, c *Container, rea
 		return nil, err
 	}
 
-	controller.running[c.Name] = *c
+	return controller.track(*c, ready, followOptions{}), nil
+}
+
+// followOptions determines how the output of a tracked container is read.
+type followOptions struct {
+	// tty is set for a container with a TTY, whose output isn't multiplexed.
+	tty bool
+	// since, if set, is the time (e.g. when the container was restarted) before which output is skipped.
+	since string
+}
+
+// track records a running container as started by the controller, follows its logs and determines its readiness in
+// the background.
+func (controller *DockerController) track(c Container, ready WaitStrategy, follow followOptions) *Readiness {
+	buffer := NewLogBuffer(c.LogBufferSize)
+
+	controller.mu.Lock()
+	controller.running[c.Name] = c
+	controller.logs[c.Name] = buffer
+	controller.mu.Unlock()
+
+	feed := newLogFeed()
+	go controller.followLogs(c, follow, feed, buffer)
+
+	readiness := newReadiness()
+	if ready != nil {
+		go controller.waitUntilReady(c, ready, feed, readiness)
+	} else {
+		feed.release()
+		readiness.resolve(nil)
+	}
+
+	return readiness
+}
 
-	readyChan := make(chan bool)
-	go controller.followLogs(resp.ID, c.Name, readyChan, ready)
+// Logs returns the most recent lines of output of the container with the given name that was started by the
+// controller, which remain available after the container has been shut down.
+func (controller *DockerController) Logs(name string) []LogLine {
+	controller.mu.Lock()
+	buffer, ok := controller.logs[name]
+	controller.mu.Unlock()
+	if !ok {
+		return nil
+	}
 
-	return readyChan, nil
+	return buffer.Lines()
 }
 
 // WaitForShutdown blocks until the specified Container has shutdown or errors within given timeout.
@@ -145,43 +375,87 @@ func (controller *DockerController) WaitForShutdown(ctx context.Context, c Conta
 		return nil
 	case err := <-errC:
 		e := DockerError{"error when waiting for container " + c.Name, err}
-		logger.Error(e)
+		controller.log().Error(e)
 		return e
 	}
 }
 
 // Shutdown terminates the specified running Container based on its ID.
 func (controller *DockerController) Shutdown(ctx context.Context, c Container) error {
-	logger.Infof("Trying to shutdown %s...", c)
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	controller.log().Infof("Trying to shutdown %s...", c)
 
 	timeout := 30 * time.Second
 	err := controller.cli.ContainerStop(ctx, c.ID, &timeout)
 	if err != nil {
-		logger.Errorf("Unable to shutdown container %s: %v", c, err)
+		controller.log().Errorf("Unable to shutdown container %s: %v", c, err)
 		return ContainerError{"unable to shutdown container", c.Name, err}
 	}
 
+	controller.mu.Lock()
 	delete(controller.running, c.Name)
+	controller.mu.Unlock()
 	return nil
 }
 
 // Remove removes the specified (stopped) container based on its ID.
 func (controller *DockerController) Remove(ctx context.Context, c Container) error {
-	logger.Infof("Trying to remove %s...", c)
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	controller.log().Infof("Trying to remove %s...", c)
 
 	err := controller.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{})
 	if err != nil {
-		logger.Errorf("Unable to remove container %s: %v", c, err)
+		controller.log().Errorf("Unable to remove container %s: %v", c, err)
 		return ContainerError{"unable to remove container", c.Name, err}
 	}
 
 	return nil
 }
 
-// ShutdownAll terminates and removes all running containers
+// ShutdownAll terminates and removes all containers started by the controller, including any containers of its
+// session that were created but failed to start. Containers started with Reuse are left running.
 func (controller *DockerController) ShutdownAll(ctx context.Context) error {
 	var allErrors []string
-	for _, c := range controller.running {
+
+	var containers []Container
+	for _, c := range controller.runningContainers() {
+		if !c.Reuse {
+			containers = append(containers, c)
+		}
+	}
+
+	running := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		running[c.ID] = true
+	}
+
+	listed, err := controller.ListContainers(ctx)
+	if err != nil {
+		allErrors = append(allErrors, err.Error())
+	}
+	for _, c := range listed {
+		if running[c.ID] {
+			continue
+		}
+
+		leftover := Container{Name: containerName(c), Image: c.Image, ID: c.ID}
+		if isRunning(c) {
+			containers = append(containers, leftover)
+			continue
+		}
+
+		// containers that aren't running (e.g. because they failed to start) only need to be removed
+		err := controller.Remove(ctx, leftover)
+		if err != nil {
+			allErrors = append(allErrors, err.Error())
+		}
+	}
+
+	for _, c := range containers {
 		err := controller.Shutdown(ctx, c)
 		if err != nil {
 			allErrors = append(allErrors, err.Error())
@@ -200,13 +474,48 @@ func (controller *DockerController) ShutdownAll(ctx context.Context) error {
 	return nil
 }
 
+// CleanupNetworks removes the networks created by the controller's session, as well as the networks it adopted,
+// except for the networks reusable containers (see Container.Reuse) are attached to.
 func (controller *DockerController) CleanupNetworks(ctx context.Context) error {
+	networks, err := controller.ListNetworks(ctx)
+	if err != nil {
+		return err
+	}
+
+	reused, err := controller.reusedNetworks(ctx)
+	if err != nil {
+		return err
+	}
+
+	controller.mu.Lock()
+	ids := make(map[string]bool, len(networks)+len(controller.adopted))
+	for id := range controller.adopted {
+		ids[id] = true
+	}
+	controller.mu.Unlock()
+	for _, network := range networks {
+		ids[network.ID] = true
+	}
+
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
 	var allErrors []string
-	for _, id := range controller.networks {
+	for id := range ids {
+		if name, ok := reused[id]; ok {
+			controller.log().Infof("Not removing network %s, reusable container %s is attached to it", id, name)
+			continue
+		}
+
 		err := controller.cli.NetworkRemove(ctx, id)
-		if err != nil {
+		if err != nil && !errdefs.IsNotFound(err) {
 			allErrors = append(allErrors, err.Error())
+			continue
 		}
+
+		controller.mu.Lock()
+		delete(controller.adopted, id)
+		controller.mu.Unlock()
 	}
 
 	msg := strings.Join(allErrors, ",")
@@ -217,10 +526,13 @@ func (controller *DockerController) CleanupNetworks(ctx context.Context) error {
 	return nil
 }
 
-func (controller DockerController) GetContainerHostPath(ctx context.Context, name string, path string) (string, error) {
+func (controller *DockerController) GetContainerHostPath(ctx context.Context, name string, path string) (string, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
 	containers, err := controller.cli.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
-		logger.Errorf("Unable to list containers: %v", err)
+		controller.log().Errorf("Unable to list containers: %v", err)
 		return "", err
 	}
 
@@ -237,7 +549,7 @@ func (controller DockerController) GetContainerHostPath(ctx context.Context, nam
 		}
 	}
 
-	logger.Warnf("Unable to find container %s with path %s", name, path)
+	controller.log().Warnf("Unable to find container %s with path %s", name, path)
 
 	return "", nil
 }
@@ -0,0 +1,602 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/go-cmp/cmp"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFakeController(t *testing.T) (*dockerlib.DockerController, *dockerlibtest.Engine) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithDockerConfig(t.TempDir()))
+	t.Cleanup(func() {
+		_ = controller.ShutdownAll(context.Background())
+		_ = controller.CleanupNetworks(context.Background())
+	})
+
+	return controller, engine
+}
+
+func TestFakeEnsureImage(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	err := controller.EnsureImage(context.Background(), "alpine")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	if !engine.HasImage("alpine:latest") {
+		t.Errorf("expected image alpine:latest to be pulled")
+	}
+}
+
+func TestFakeEnsureImagePullFailure(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Fail("ImagePull", dockerlibtest.Unavailable("registry unavailable"))
+
+	err := controller.EnsureImage(context.Background(), "alpine")
+	if err == nil {
+		t.Fatal("expected error when pull fails")
+	}
+}
+
+func TestFakeEnsureImageStreamError(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.FailPull("private/image", "pull access denied for private/image")
+
+	err := controller.EnsureImage(context.Background(), "private/image")
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Fatalf("expected ImagePullError, got %v", err)
+	}
+
+	if pullErr.Image != "private/image" || pullErr.Message != "pull access denied for private/image" {
+		t.Errorf("unexpected pull error: %+v", pullErr)
+	}
+
+	if engine.HasImage("private/image") {
+		t.Error("expected image not to be pulled")
+	}
+}
+
+// truncatedReader reads at most n bytes and then fails with err, like a stream whose connection is cut off.
+type truncatedReader struct {
+	reader io.Reader
+	n      int
+	err    error
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, r.err
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+
+	n, err := r.reader.Read(p)
+	r.n -= n
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+// truncatedPullEngine cuts off the progress stream of pulls part-way through a message.
+type truncatedPullEngine struct {
+	*dockerlibtest.Engine
+}
+
+func (e truncatedPullEngine) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	reader, err := e.Engine.ImagePull(ctx, ref, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(&truncatedReader{reader, 100, errors.New("connection reset by peer")}), nil
+}
+
+func TestFakeEnsureImageTruncatedStream(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	controller := dockerlib.NewDockerControllerFromClient(truncatedPullEngine{engine})
+
+	err := controller.EnsureImage(context.Background(), "alpine")
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Fatalf("expected ImagePullError when the pull stream is cut off, got %v", err)
+	}
+	if pullErr.Image != "alpine" || errors.Unwrap(pullErr) == nil || !strings.Contains(err.Error(), "connection reset by peer") {
+		t.Errorf("unexpected pull error: %+v", pullErr)
+	}
+}
+
+func TestFakeEnsureImageProgress(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	var events []dockerlib.EnsureImageProgress
+	err := controller.EnsureImage(context.Background(), "alpine", dockerlib.WithPullProgress(func(p dockerlib.EnsureImageProgress) {
+		events = append(events, p)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	if len(events) == 0 {
+		t.Fatal("expected progress events")
+	}
+
+	layers := make(map[string]float64)
+	previous := 0.0
+	for _, event := range events {
+		if event.Percent < previous {
+			t.Errorf("expected aggregate progress not to go backwards, got %v after %v", event.Percent, previous)
+		}
+		previous = event.Percent
+
+		if event.Status == "Downloading" && event.LayerPercent != 25 {
+			t.Errorf("expected layer %s to be 25%% complete while downloading, got %v", event.ID, event.LayerPercent)
+		}
+
+		if event.Status == "Pull complete" {
+			layers[event.ID] = event.LayerPercent
+		}
+	}
+
+	if len(layers) != 2 {
+		t.Errorf("expected 2 layers to complete, got %v", layers)
+	}
+
+	for id, percent := range layers {
+		if percent != 100 {
+			t.Errorf("expected layer %s to be 100%% complete, got %v", id, percent)
+		}
+	}
+
+	if last := events[len(events)-1]; last.Percent != 100 {
+		t.Errorf("expected pull to be 100%% complete, got %v", last.Percent)
+	}
+}
+
+func TestFakeEnsureImagePullPolicy(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		image   string
+		policy  dockerlib.PullPolicy
+		pulls   int
+		wantErr bool
+	}{
+		{"always pulls present image", "alpine", dockerlib.PullAlways, 1, false},
+		{"if not present skips present image", "alpine", dockerlib.PullIfNotPresent, 0, false},
+		{"if not present pulls missing image", "busybox", dockerlib.PullIfNotPresent, 1, false},
+		{"if not present pulls different digest", "alpine@" + digest, dockerlib.PullIfNotPresent, 1, false},
+		{"never skips present image", "alpine", dockerlib.PullNever, 0, false},
+		{"never fails for missing image", "busybox", dockerlib.PullNever, 0, true},
+		{"unknown policy fails", "alpine", dockerlib.PullPolicy("sometimes"), 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller, engine := newFakeController(t)
+			engine.AddImage("alpine")
+
+			err := controller.EnsureImage(context.Background(), test.image, dockerlib.WithPullPolicy(test.policy))
+
+			var pullErr dockerlib.ImagePullError
+			if test.wantErr && !errors.As(err, &pullErr) {
+				t.Errorf("expected ImagePullError, got %v", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error when ensuring image: %v", err)
+			}
+
+			if pulls := engine.Calls("ImagePull"); pulls != test.pulls {
+				t.Errorf("expected %d pulls, got %d", test.pulls, pulls)
+			}
+
+			if !test.wantErr && !engine.HasImage(test.image) {
+				t.Errorf("expected image %s to be present", test.image)
+			}
+		})
+	}
+}
+
+func TestFakeStartEnsuresImage(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: "busybox", PullPolicy: dockerlib.PullIfNotPresent}
+	_, err := controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	if !engine.HasImage("busybox") {
+		t.Error("expected image busybox to be pulled")
+	}
+
+	container = dockerlib.Container{Name: "dockerlib-test-never", Image: "postgres", PullPolicy: dockerlib.PullNever}
+	_, err = controller.Start(context.Background(), &container, nil)
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Errorf("expected ImagePullError, got %v", err)
+	}
+
+	if engine.Calls("ContainerCreate") != 1 {
+		t.Errorf("expected container not to be created without its image")
+	}
+}
+
+func TestFakeEnsureImagePlatform(t *testing.T) {
+	arm64 := specs.Platform{OS: "linux", Architecture: "arm64"}
+
+	tests := []struct {
+		name     string
+		platform specs.Platform
+		policy   dockerlib.PullPolicy
+		pulls    int
+		wantArch string
+		wantErr  bool
+	}{
+		{"always pulls requested platform", arm64, dockerlib.PullAlways, 1, "arm64", false},
+		{"if not present skips matching platform", specs.Platform{OS: "linux", Architecture: "x86_64"}, dockerlib.PullIfNotPresent, 0, "amd64", false},
+		{"if not present pulls other platform", arm64, dockerlib.PullIfNotPresent, 1, "arm64", false},
+		{"never fails for other platform", arm64, dockerlib.PullNever, 0, "amd64", true},
+		{"unavailable platform fails", specs.Platform{OS: "linux", Architecture: "s390x"}, dockerlib.PullAlways, 1, "amd64", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller, engine := newFakeController(t)
+			engine.AddImage("alpine")
+			engine.SetPlatforms("alpine", "linux/amd64", "linux/arm64")
+
+			err := controller.EnsureImage(context.Background(), "alpine", dockerlib.WithPullPolicy(test.policy), dockerlib.WithPullPlatform(test.platform))
+
+			var pullErr dockerlib.ImagePullError
+			if test.wantErr && !errors.As(err, &pullErr) {
+				t.Errorf("expected ImagePullError, got %v", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error when ensuring image: %v", err)
+			}
+
+			if pulls := engine.Calls("ImagePull"); pulls != test.pulls {
+				t.Errorf("expected %d pulls, got %d", test.pulls, pulls)
+			}
+
+			info, err := controller.ImageInspect(context.Background(), "alpine")
+			if err != nil {
+				t.Fatalf("unexpected error when inspecting image: %v", err)
+			}
+
+			if info.OS != "linux" || info.Architecture != test.wantArch {
+				t.Errorf("expected image for linux/%s, got %s/%s", test.wantArch, info.OS, info.Architecture)
+			}
+		})
+	}
+}
+
+func TestFakeStartPlatform(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.AddImageForPlatform("linux/arm64", "example/app:arm64")
+
+	container := dockerlib.Container{Name: "dockerlib-test-amd64", Image: "example/app:arm64", Platform: &specs.Platform{OS: "linux", Architecture: "amd64"}}
+	_, err := controller.Start(context.Background(), &container, nil)
+
+	var platformErr dockerlib.ImagePlatformError
+	if !errors.As(err, &platformErr) {
+		t.Fatalf("expected ImagePlatformError, got %v", err)
+	}
+
+	if platformErr.Platform != "linux/amd64" || platformErr.ImagePlatform != "linux/arm64" {
+		t.Errorf("unexpected platforms in error: %v", platformErr)
+	}
+
+	if engine.Calls("ContainerCreate") != 0 {
+		t.Error("expected container not to be created for an image of another platform")
+	}
+
+	container = dockerlib.Container{Name: "dockerlib-test-arm64", Image: "example/app:arm64", Platform: &specs.Platform{OS: "linux", Architecture: "aarch64"}}
+	_, err = controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	container = dockerlib.Container{
+		Name:       "dockerlib-test-pulled",
+		Image:      "busybox",
+		PullPolicy: dockerlib.PullIfNotPresent,
+		Platform:   &specs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}
+	_, err = controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	info, err := controller.ImageInspect(context.Background(), "busybox")
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting image: %v", err)
+	}
+
+	if info.Architecture != "arm" || info.Variant != "v7" {
+		t.Errorf("expected busybox to be pulled for linux/arm/v7, got %s/%s/%s", info.OS, info.Architecture, info.Variant)
+	}
+}
+
+func TestFakeStartReady(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{
+			dockerlibtest.Stdout("Starting"),
+			dockerlibtest.Stdout("INFO:root:Hello!").After(10 * time.Millisecond),
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	ready, err := controller.Start(ctx, &container, dockerlib.ForLog("Hello"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	select {
+	case <-ready.Done():
+		if err := ready.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Test timeout - container didn't start.")
+	}
+
+	if state, _ := engine.ContainerState("dockerlib-test"); state != "running" {
+		t.Errorf("expected container to be running, got %s", state)
+	}
+}
+
+func TestFakeStartCreateConflict(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Fail("ContainerCreate", dockerlibtest.Conflict("container name already in use"))
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(context.Background(), &container, dockerlib.ForLog("Hello"))
+	if err == nil || !strings.Contains(err.Error(), "unable to create container dockerlib-test") {
+		t.Fatalf("expected create error, got %v", err)
+	}
+}
+
+func TestFakeStartMissingImage(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: "missing"}
+	_, err := controller.Start(context.Background(), &container, dockerlib.ForLog("Hello"))
+	if err == nil {
+		t.Fatal("expected error when image is missing")
+	}
+}
+
+func TestFakeStartStartFailure(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Fail("ContainerStart", dockerlibtest.Conflict("port is already allocated"))
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(context.Background(), &container, dockerlib.ForLog("Hello"))
+	if err == nil || !strings.Contains(err.Error(), "unable to start container dockerlib-test") {
+		t.Fatalf("expected start error, got %v", err)
+	}
+}
+
+func TestFakeStartMissingNetwork(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage, Network: []string{"missing"}}
+	_, err := controller.Start(context.Background(), &container, dockerlib.ForLog("Hello"))
+	if err == nil || !strings.Contains(err.Error(), "unable to find networks [missing]") {
+		t.Fatalf("expected missing network error, got %v", err)
+	}
+}
+
+func TestFakeEnsureNetwork(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	err := controller.EnsureNetwork(context.Background(), "dockerlib")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage, Network: []string{"dockerlib"}}
+	_, err = controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = controller.ShutdownAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down: %v", err)
+	}
+
+	err = controller.CleanupNetworks(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error when cleaning up networks: %v", err)
+	}
+
+	if engine.NetworkExists("dockerlib") {
+		t.Errorf("expected network to be removed")
+	}
+}
+
+func TestFakeEnsureNetworkFailure(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Fail("NetworkCreate", dockerlibtest.Forbidden("pool overlaps with other one on this address space"))
+
+	err := controller.EnsureNetwork(context.Background(), "dockerlib")
+	if err == nil || !strings.Contains(err.Error(), "unable to create network dockerlib") {
+		t.Fatalf("expected network error, got %v", err)
+	}
+}
+
+func TestFakeWaitForShutdown(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines:    []dockerlibtest.Line{dockerlibtest.Stdout("Done").After(10 * time.Millisecond)},
+		Exit:     true,
+		ExitCode: 3,
+	})
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = controller.WaitForShutdown(context.Background(), container, time.Second)
+	if err != nil {
+		t.Errorf("unexpected error when waiting for shutdown: %v", err)
+	}
+
+	if state, _ := engine.ContainerState("dockerlib-test"); state != "exited" {
+		t.Errorf("expected container to have exited, got %s", state)
+	}
+}
+
+func TestFakeWaitForShutdownTimeout(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = controller.WaitForShutdown(context.Background(), container, 50*time.Millisecond)
+	if err == nil {
+		t.Errorf("expected error when container doesn't shutdown")
+	}
+}
+
+func TestFakeGetContainerHostPath(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.MountSourcePrefix = "/host_mnt"
+
+	container := dockerlib.Container{
+		Name:  "dockerlib-test-server",
+		Image: TestImage,
+		Mounts: []mount.Mount{
+			{Source: "/abs/testdata/hello.txt", Target: "/site/hello.txt", Type: mount.TypeBind},
+		},
+	}
+	_, err := controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	path, err := controller.GetContainerHostPath(context.Background(), "dockerlib-test-server", "/site/hello.txt")
+	if err != nil {
+		t.Fatalf("Error when getting container host path: %v", err)
+	}
+
+	if path != "/abs/testdata/hello.txt" {
+		t.Errorf("Expected host path to be /abs/testdata/hello.txt, but got %s", path)
+	}
+}
+
+func TestFakeStartContainerExits(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines:    []dockerlibtest.Line{dockerlibtest.Stderr("ERROR:root:Unable to bind port")},
+		Exit:     true,
+		ExitCode: 1,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	ready, err := controller.Start(ctx, &container, dockerlib.ForLog("Hello"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = ready.Wait(ctx)
+	var exitErr dockerlib.ContainerExitedError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected ContainerExitedError, got %v", err)
+	}
+
+	if exitErr.ExitCode != 1 {
+		t.Errorf("expected exit code 1, got %d", exitErr.ExitCode)
+	}
+
+	expected := []dockerlib.LogLine{{Stream: dockerlib.Stderr, Text: "ERROR:root:Unable to bind port"}}
+	if !cmp.Equal(exitErr.Logs, expected) {
+		t.Errorf("expected last logs to be reported, got %v", exitErr.Logs)
+	}
+}
+
+func TestFakeStartLogsEnded(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Fail("ContainerWait", dockerlibtest.Unavailable("wait not supported"))
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{dockerlibtest.Stdout("Starting")},
+		Exit:  true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	ready, err := controller.Start(ctx, &container, dockerlib.ForLog("Hello"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = ready.Wait(ctx)
+	var logsErr dockerlib.LogsEndedError
+	if !errors.As(err, &logsErr) || !errors.Is(err, dockerlib.ErrLogsEnded) {
+		t.Fatalf("expected LogsEndedError, got %v", err)
+	}
+}
+
+func TestFakeStartReadinessTimeout(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{dockerlibtest.Stdout("Starting")},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	ready, err := controller.Start(ctx, &container, dockerlib.WithStartupTimeout(dockerlib.ForLog("Hello"), 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = ready.Wait(ctx)
+	var timeoutErr dockerlib.ReadinessTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected ReadinessTimeoutError, got %v", err)
+	}
+
+	expected := []dockerlib.LogLine{{Stream: dockerlib.Stdout, Text: "Starting"}}
+	if !cmp.Equal(timeoutErr.Logs, expected) {
+		t.Errorf("expected last logs to be reported, got %q", timeoutErr.Logs)
+	}
+}
@@ -59,14 +59,18 @@ func TestBasicStartImage(t *testing.T) {
 		Network:     nil,
 	}
 
-	ready, err := controller.Start(ctx, &container, "Hello")
+	ready, err := controller.Start(ctx, &container, dockerlib.ForLog("Hello"))
 	if err != nil {
 		t.Error(err)
+		t.FailNow()
 	}
 	defer controller.ShutdownAll(context.Background())
 
 	select {
-	case <-ready:
+	case <-ready.Done():
+		if err := ready.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
 		return
 	case <-ctx.Done():
 		t.Error("Test timeout - container didn't start.")
@@ -140,7 +144,7 @@ func TestStartWithNetwork(t *testing.T) {
 		Network:     []string{network},
 	}
 
-	ready, err := controller.Start(ctx, &server, "Server started on port")
+	ready, err := controller.Start(ctx, &server, dockerlib.ForLog("Server started on port"))
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
@@ -148,13 +152,16 @@ func TestStartWithNetwork(t *testing.T) {
 	defer controller.Shutdown(context.Background(), server)
 
 	select {
-	case <-ready:
+	case <-ready.Done():
+		if err := ready.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
 	case <-ctx.Done():
 		t.Error("Test timeout - server didn't start.")
 		t.FailNow()
 	}
 
-	status, err := controller.Start(ctx, &client, "Status")
+	status, err := controller.Start(ctx, &client, dockerlib.ForLog("Status"))
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
@@ -162,7 +169,10 @@ func TestStartWithNetwork(t *testing.T) {
 	defer controller.ShutdownAll(context.Background())
 
 	select {
-	case <-status:
+	case <-status.Done():
+		if err := status.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
 		return
 	case <-ctx.Done():
 		t.Error("Test timeout - client didn't start.")
@@ -218,7 +228,7 @@ func TestGetContainerHostPath(t *testing.T) {
 		Network:     []string{network},
 	}
 
-	ready, err := controller.Start(ctx, &server, "Server started on port")
+	ready, err := controller.Start(ctx, &server, dockerlib.ForLog("Server started on port"))
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
@@ -226,7 +236,10 @@ func TestGetContainerHostPath(t *testing.T) {
 	defer controller.ShutdownAll(context.Background())
 
 	select {
-	case <-ready:
+	case <-ready.Done():
+		if err := ready.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
 	case <-ctx.Done():
 		t.Error("Test timeout - server didn't start.")
 		t.FailNow()
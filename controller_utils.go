@@ -2,42 +2,153 @@ package dockerlib
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/containerd/containerd/platforms"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/network"
-	"strings"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strconv"
 )
 
 // Helper method to follow logs of running container.
-func (controller *DockerController) followLogs(containerID string, containerName string, readyChan chan<- bool, readyText string) {
-	logOptions := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true}
+func (controller *DockerController) followLogs(c Container, follow followOptions, feed *logFeed, buffer *LogBuffer) {
+	defer feed.close()
+
+	logOptions := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true, Since: follow.since}
 
 	// logs need to be in background context so they aren't canceled before container.
-	reader, err := controller.cli.ContainerLogs(context.Background(), containerID, logOptions)
+	reader, err := controller.cli.ContainerLogs(context.Background(), c.ID, logOptions)
 	if err != nil {
-		logger.Errorf("Unable to follow logs for container %s: %v", containerName, err)
+		controller.log().Errorf("Unable to follow logs for container %s: %v", c.Name, err)
 		return
 	}
 	defer reader.Close()
 
-	cLogger := logger.Named(containerName)
-	lines := ReadLinesAsBytes(reader)
+	cLogger := controller.log().Named(c.Name).Desugar()
+	var lines <-chan LogLine
+	if follow.tty {
+		lines = readTTYLines(reader)
+	} else {
+		lines = ReadLogLines(reader)
+	}
 	for line := range lines {
-		text := string(line)
-		cLogger.Info(text)
-		if len(readyText) > 0 && strings.Contains(text, readyText) {
-			readyChan <- true
-			close(readyChan)
+		logLine(cLogger, c.LogParser, line)
+		buffer.Accept(line)
+		for _, consumer := range c.LogConsumers {
+			consumer.Accept(line)
+		}
+
+		// publish last so consumers have seen a line before readiness can be determined from it.
+		feed.publish(line)
+	}
+
+	controller.log().Infof("Logs finished for container %s", c.Name)
+}
+
+// Helper method to log a line of container output, using the level and fields decoded by the parser if possible.
+func logLine(logger *zap.Logger, parser LogParser, line LogLine) {
+	stream := zap.Stringer("stream", line.Stream)
+	if parser != nil {
+		if parsed, ok := parser.Parse(line); ok {
+			if entry := logger.Check(parsed.Level, parsed.Message); entry != nil {
+				entry.Write(append(parsed.Fields, stream)...)
+			}
+			return
+		}
+	}
+
+	logger.Info(line.Text, stream)
+}
+
+// Helper method to run a command inside a container and wait for its exit code.
+func (controller *DockerController) execExitCode(ctx context.Context, c Container, cmd []string) (int, error) {
+	created, err := controller.cli.ContainerExecCreate(ctx, c.ID, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return 0, ContainerError{"unable to create exec in container", c.Name, err}
+	}
+
+	err = controller.cli.ContainerExecStart(ctx, created.ID, types.ExecStartCheck{Detach: true})
+	if err != nil {
+		return 0, ContainerError{"unable to start exec in container", c.Name, err}
+	}
+
+	return controller.execWait(ctx, c, created.ID)
+}
+
+// Helper method to determine the host on which published container ports can be reached.
+func (controller *DockerController) host() string {
+	hostURL, err := client.ParseHostURL(controller.cli.DaemonHost())
+	if err != nil || (hostURL.Scheme != "tcp" && hostURL.Scheme != "http" && hostURL.Scheme != "https") {
+		return "localhost"
+	}
+
+	host, _, err := net.SplitHostPort(hostURL.Host)
+	if err != nil {
+		return hostURL.Host
+	}
+
+	return host
+}
+
+// waitTarget is the WaitTarget for a container started by a DockerController.
+type waitTarget struct {
+	controller *DockerController
+	container  Container
+	logs       *logFeed
+}
+
+func (t *waitTarget) Container() Container {
+	return t.container
+}
+
+func (t *waitTarget) Logs(ctx context.Context) <-chan LogLine {
+	return t.logs.subscribe(ctx)
+}
+
+func (t *waitTarget) Host() string {
+	return t.controller.host()
+}
+
+func (t *waitTarget) MappedPort(ctx context.Context, port int) (int, error) {
+	if hostPort, ok := t.container.Ports[port]; ok && hostPort != 0 {
+		return hostPort, nil
+	}
+
+	info, err := t.Inspect(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.NetworkSettings != nil {
+		for _, binding := range info.NetworkSettings.Ports[nat.Port(fmt.Sprintf("%d/tcp", port))] {
+			if hostPort, err := strconv.Atoi(binding.HostPort); err == nil && hostPort != 0 {
+				return hostPort, nil
+			}
 		}
 	}
 
-	logger.Infof("Logs finished for container %s", containerName)
+	return 0, fmt.Errorf("port %d of container %s is not published", port, t.container.Name)
+}
+
+func (t *waitTarget) Inspect(ctx context.Context) (types.ContainerJSON, error) {
+	return t.controller.cli.ContainerInspect(ctx, t.container.ID)
+}
+
+func (t *waitTarget) Exec(ctx context.Context, cmd []string) (int, error) {
+	return t.controller.execExitCode(ctx, t.container, cmd)
 }
 
 func (controller *DockerController) attachNetworks(ctx context.Context, container Container) error {
 	networks, err := controller.cli.NetworkList(ctx, types.NetworkListOptions{})
 	if err != nil {
-		logger.Errorf("Unable to list networks: %v", err)
+		controller.log().Errorf("Unable to list networks: %v", err)
 		return DockerError{"unable to list networks", err}
 	}
 
@@ -53,10 +164,10 @@ func (controller *DockerController) attachNetworks(ctx context.Context, containe
 			continue
 		}
 
-		logger.Infof("Attaching network %+v to container %s", nw, container.Name)
+		controller.log().Infof("Attaching network %+v to container %s", nw, container.Name)
 		err := controller.cli.NetworkConnect(ctx, nw.ID, container.ID, &network.EndpointSettings{})
 		if err != nil {
-			logger.Errorf("Unable to attach network %s to container %s: %v", nw.Name, container.Name, err)
+			controller.log().Errorf("Unable to attach network %s to container %s: %v", nw.Name, container.Name, err)
 			return ContainerError{
 				msg:           "unable to attach network " + nw.Name + " to container",
 				containerName: container.Name,
@@ -76,9 +187,158 @@ func (controller *DockerController) attachNetworks(ctx context.Context, containe
 	}
 
 	if len(notFound) > 0 {
-		logger.Errorf("Unable to find networks %v to attach to container %s", notFound, container.Name)
+		controller.log().Errorf("Unable to find networks %v to attach to container %s", notFound, container.Name)
 		return fmt.Errorf("unable to find networks %v to attach to container %s", notFound, container.Name)
 	}
 
 	return nil
 }
+
+// runningContainers returns a snapshot of the containers started by the controller that have not been shut down.
+func (controller *DockerController) runningContainers() []Container {
+	controller.mu.Lock()
+	defer controller.mu.Unlock()
+
+	containers := make([]Container, 0, len(controller.running))
+	for _, c := range controller.running {
+		containers = append(containers, c)
+	}
+
+	return containers
+}
+
+// imagePresent returns whether the given image reference is available locally, taking its digest and, if given,
+// its platform into account.
+func (controller *DockerController) imagePresent(ctx context.Context, image string, platform *specs.Platform) (bool, error) {
+	inspect, _, err := controller.cli.ImageInspectWithRaw(ctx, image)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		controller.log().Errorf("Unable to inspect image %s: %v", image, err)
+		return false, DockerError{"unable to inspect image " + image, err}
+	}
+
+	if platform != nil && !matchesPlatform(inspect, *platform) {
+		controller.log().Infof("Image %s is for platform %s, not %s", image, platforms.Format(imagePlatform(inspect)), platforms.Format(*platform))
+		return false, nil
+	}
+
+	return hasImage(inspect, image), nil
+}
+
+// checkPlatform returns an ImagePlatformError if the local image isn't for the given platform.
+func (controller *DockerController) checkPlatform(ctx context.Context, image string, platform specs.Platform) error {
+	inspect, _, err := controller.cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		controller.log().Errorf("Unable to inspect image %s: %v", image, err)
+		return ImageError{"unable to inspect image", image, err}
+	}
+
+	if !matchesPlatform(inspect, platform) {
+		err := ImagePlatformError{
+			Image:         image,
+			Platform:      platforms.Format(platforms.Normalize(platform)),
+			ImagePlatform: platforms.Format(imagePlatform(inspect)),
+		}
+		controller.log().Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// imagePullOptions resolves the credentials for the registry hosting the given image, if there are any, and the
+// platform to pull.
+func (controller *DockerController) imagePullOptions(image string, options pullOptions) (types.ImagePullOptions, error) {
+	auth, ok := options.auth, options.auth != nil
+	if !ok {
+		resolved, found, err := controller.auth.resolve(image)
+		if err != nil {
+			controller.log().Errorf("Unable to resolve credentials for image %s: %v", image, err)
+			return types.ImagePullOptions{}, ImagePullError{Image: image, Message: "unable to resolve registry credentials: " + err.Error(), baseError: err}
+		}
+		auth, ok = &resolved, found
+	}
+
+	var pullOptions types.ImagePullOptions
+	if options.platform != nil {
+		pullOptions.Platform = platforms.Format(platforms.Normalize(*options.platform))
+	}
+
+	if !ok {
+		return pullOptions, nil
+	}
+
+	encoded, err := encodeAuth(*auth)
+	if err != nil {
+		return types.ImagePullOptions{}, ImagePullError{Image: image, Message: "unable to encode registry credentials: " + err.Error(), baseError: err}
+	}
+
+	controller.log().Infof("Pulling image %s with credentials for %s", image, registryOf(image))
+	pullOptions.RegistryAuth = encoded
+	return pullOptions, nil
+}
+
+// readProgress decodes the stream of JSON messages Docker sends while pulling or building an image, passing each
+// message to fn. The stream is read to the end even if it reports an error, and the first error is returned, along
+// with any error reading the stream, e.g. when it was cut off.
+func (controller *DockerController) readProgress(reader io.Reader, fn func(EnsureImageProgress)) (string, int, bool, error) {
+	var msg string
+	var code int
+	var failed bool
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var progress EnsureImageProgress
+		err := decoder.Decode(&progress)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			controller.log().Errorf("Unable to unmarshall progress: %v", err)
+			return msg, code, failed, err
+		}
+
+		if m, c, f := progress.failure(); f {
+			if !failed {
+				msg, code, failed = m, c, f
+			}
+			continue
+		}
+
+		fn(progress)
+	}
+
+	return msg, code, failed, nil
+}
+
+// pullFallback loads an image that couldn't be pulled from the image cache, returning the pull error, along with
+// why the cache couldn't be used, if the cache doesn't contain it. The cache isn't used if ctx is done, since that
+// is usually why the pull failed.
+func (controller *DockerController) pullFallback(ctx context.Context, pullErr ImagePullError) error {
+	if len(controller.imageCache) == 0 {
+		return pullErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		controller.log().Errorf("Not loading image %s from cache: %v", pullErr.Image, err)
+		pullErr.Message += "; not loaded from image cache: " + err.Error()
+		pullErr.baseError = err
+		return pullErr
+	}
+
+	loaded, err := controller.loadFromCache(ctx, pullErr.Image)
+	switch {
+	case err != nil:
+		controller.log().Errorf("Unable to load image %s from cache: %v", pullErr.Image, err)
+		pullErr.Message += "; unable to load it from image cache: " + err.Error()
+		return pullErr
+	case !loaded:
+		pullErr.Message += "; not found in image cache " + controller.imageCache
+		return pullErr
+	}
+
+	controller.log().Infof("Loaded image %s from cache after failing to pull it", pullErr.Image)
+	return nil
+}
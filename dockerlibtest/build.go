@@ -0,0 +1,340 @@
+package dockerlibtest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Build records a build made with ImageBuild, including the files sent as its build context.
+type Build struct {
+	Options types.ImageBuildOptions
+	Files   map[string][]byte
+}
+
+// exitPattern matches RUN instructions that simulate a failing command, e.g. RUN make || exit 2.
+var exitPattern = regexp.MustCompile(`exit (\d+)`)
+
+// instruction is a single instruction of a Dockerfile, e.g. RUN with args "make".
+type instruction struct {
+	command string
+	args    string
+}
+
+func (i instruction) String() string {
+	return i.command + " " + i.args
+}
+
+// Builds returns the builds that have been made, in order.
+func (e *Engine) Builds() []Build {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]Build(nil), e.builds...)
+}
+
+// ImageBuild simulates building an image from the Dockerfile in the build context. Instructions aren't executed,
+// except that RUN instructions print the arguments of echo and fail for `exit N` with a non-zero N, and COPY and
+// ADD instructions fail if their sources aren't in the build context. The built image has the configuration (CMD,
+// ENV, EXPOSE and LABEL) described by the Dockerfile.
+func (e *Engine) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	files, err := readBuildContext(buildContext)
+	if err != nil {
+		return types.ImageBuildResponse{}, errdefs.InvalidParameter(err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImageBuild"); err != nil {
+		return types.ImageBuildResponse{}, err
+	}
+	e.builds = append(e.builds, Build{Options: options, Files: files})
+
+	dockerfile := options.Dockerfile
+	if len(dockerfile) == 0 {
+		dockerfile = "Dockerfile"
+	}
+
+	contents, ok := files[path.Clean(dockerfile)]
+	if !ok {
+		return types.ImageBuildResponse{}, errdefs.InvalidParameter(fmt.Errorf("Cannot locate specified Dockerfile: %s", dockerfile))
+	}
+
+	instructions, err := parseDockerfile(contents, options.Target)
+	if err != nil {
+		return types.ImageBuildResponse{}, errdefs.InvalidParameter(err)
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	stream := func(format string, args ...interface{}) {
+		_ = encoder.Encode(map[string]string{"stream": fmt.Sprintf(format, args...)})
+	}
+	fail := func(code int, format string, args ...interface{}) (types.ImageBuildResponse, error) {
+		msg := fmt.Sprintf(format, args...)
+		_ = encoder.Encode(map[string]interface{}{"errorDetail": map[string]interface{}{"code": code, "message": msg}, "error": msg})
+		return types.ImageBuildResponse{Body: ioutil.NopCloser(&buffer), OSType: "linux"}, nil
+	}
+
+	config := &container.Config{Labels: make(map[string]string)}
+	base := &image{platform: defaultPlatform}
+	declared := make(map[string]bool)
+	for i, inst := range instructions {
+		stream("Step %d/%d : %s\n", i+1, len(instructions), inst)
+
+		switch inst.command {
+		case "FROM":
+			from := strings.Fields(inst.args)[0]
+			img := e.findImage(from)
+			if img == nil {
+				stream("%s: Pulling from %s\n", from, repository(from))
+				img = e.addImage(from)
+			}
+			// a new stage starts from the configuration of its base image
+			config, base = img.configCopy(), img
+			stream(" ---> %s\n", shortID(img.id))
+			continue
+		case "RUN":
+			stream(" ---> Running in %s\n", shortID(e.newID("container")))
+			if strings.HasPrefix(inst.args, "echo ") {
+				stream("%s\n", strings.Trim(strings.TrimPrefix(inst.args, "echo "), `"'`))
+			}
+			if match := exitPattern.FindStringSubmatch(inst.args); match != nil && match[1] != "0" {
+				code, _ := strconv.Atoi(match[1])
+				return fail(code, "The command '/bin/sh -c %s' returned a non-zero code: %d", inst.args, code)
+			}
+		case "COPY", "ADD":
+			sources := strings.Fields(inst.args)
+			if len(sources) > 0 && !strings.HasPrefix(sources[0], "--from") {
+				for _, source := range sources[:len(sources)-1] {
+					if strings.HasPrefix(source, "--") || strings.Contains(source, "://") || hasFile(files, source) {
+						continue
+					}
+					return fail(1, "%s failed: file not found in build context or excluded by .dockerignore: stat %s: file does not exist", inst.command, source)
+				}
+			}
+		case "ARG":
+			declared[strings.SplitN(inst.args, "=", 2)[0]] = true
+		case "ENV":
+			config.Env = append(config.Env, envFromArgs(inst.args)...)
+		case "CMD":
+			config.Cmd = commandFromArgs(inst.args)
+		case "EXPOSE":
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = make(nat.PortSet)
+			}
+			for _, port := range strings.Fields(inst.args) {
+				if !strings.Contains(port, "/") {
+					port += "/tcp"
+				}
+				config.ExposedPorts[nat.Port(port)] = struct{}{}
+			}
+		case "LABEL":
+			for key, value := range labelsFromArgs(inst.args) {
+				config.Labels[key] = value
+			}
+		}
+
+		stream(" ---> %s\n", shortID(e.newID("layer")))
+	}
+
+	var unused []string
+	for arg := range options.BuildArgs {
+		if !declared[arg] {
+			unused = append(unused, arg)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		stream("[Warning] One or more build-args %v were not consumed\n", unused)
+	}
+
+	for key, value := range options.Labels {
+		config.Labels[key] = value
+	}
+
+	size := base.size
+	for _, contents := range files {
+		size += int64(len(contents))
+	}
+
+	img := &image{id: "sha256:" + e.newID("image"), config: config, size: size, created: time.Now(), platform: base.platform}
+	e.images[img.id] = img
+	for _, tag := range options.Tags {
+		e.tagImage(img, tag)
+	}
+
+	_ = encoder.Encode(map[string]interface{}{"aux": map[string]string{"ID": img.id}})
+	stream("Successfully built %s\n", shortID(img.id))
+	for _, tag := range img.refs {
+		stream("Successfully tagged %s\n", tag)
+	}
+
+	return types.ImageBuildResponse{Body: ioutil.NopCloser(&buffer), OSType: "linux"}, nil
+}
+
+// readBuildContext reads the regular files in the tar archive of a build context.
+func readBuildContext(buildContext io.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if buildContext == nil {
+		return files, nil
+	}
+
+	reader := tar.NewReader(buildContext)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(header.Name)] = contents
+	}
+}
+
+// parseDockerfile splits a Dockerfile into instructions, stopping after the given target stage if there is one.
+func parseDockerfile(contents []byte, target string) ([]instruction, error) {
+	var instructions []instruction
+	var continued string
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			continued += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		line, continued = continued+line, ""
+		parts := strings.SplitN(line, " ", 2)
+		inst := instruction{command: strings.ToUpper(parts[0])}
+		if len(parts) > 1 {
+			inst.args = strings.TrimSpace(parts[1])
+		}
+		instructions = append(instructions, inst)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(instructions) == 0 || instructions[0].command != "FROM" && instructions[0].command != "ARG" {
+		return nil, fmt.Errorf("dockerfile parse error: file with no instructions or not starting with FROM")
+	}
+
+	if len(target) == 0 {
+		return instructions, nil
+	}
+
+	for i, inst := range instructions {
+		fields := strings.Fields(inst.args)
+		if inst.command != "FROM" || len(fields) != 3 || !strings.EqualFold(fields[1], "as") || fields[2] != target {
+			continue
+		}
+
+		for j := i + 1; j < len(instructions); j++ {
+			if instructions[j].command == "FROM" {
+				return instructions[:j], nil
+			}
+		}
+		return instructions, nil
+	}
+
+	return nil, fmt.Errorf("failed to reach build target %s in Dockerfile", target)
+}
+
+// hasFile returns whether the build context contains the given file or directory.
+func hasFile(files map[string][]byte, name string) bool {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if name == "." {
+		return true
+	}
+
+	for file := range files {
+		if matched, _ := path.Match(name, file); matched || file == name || strings.HasPrefix(file, name+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// envFromArgs converts the arguments of ENV (either `KEY value` or `KEY=value ...`) to environment variables.
+func envFromArgs(args string) []string {
+	if !strings.Contains(strings.Fields(args)[0], "=") {
+		parts := strings.SplitN(args, " ", 2)
+		if len(parts) == 1 {
+			return []string{parts[0] + "="}
+		}
+		return []string{parts[0] + "=" + strings.TrimSpace(parts[1])}
+	}
+
+	var env []string
+	for key, value := range labelsFromArgs(args) {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// labelsFromArgs converts `key=value ...` arguments, as used by LABEL and ENV, to a map.
+func labelsFromArgs(args string) map[string]string {
+	labels := make(map[string]string)
+	for _, field := range strings.Fields(args) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) == 2 {
+			labels[strings.Trim(parts[0], `"`)] = strings.Trim(parts[1], `"`)
+		}
+	}
+
+	return labels
+}
+
+// commandFromArgs converts the arguments of CMD, in either exec or shell form, to a command.
+func commandFromArgs(args string) []string {
+	var cmd []string
+	if err := json.Unmarshal([]byte(args), &cmd); err == nil {
+		return cmd
+	}
+
+	return []string{"/bin/sh", "-c", args}
+}
+
+// shortID returns the abbreviated form of an ID that Docker displays.
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
@@ -0,0 +1,661 @@
+package dockerlibtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	stateCreated = "created"
+	stateRunning = "running"
+	stateExited  = "exited"
+)
+
+type fakeContainer struct {
+	id         string
+	name       string
+	imageID    string
+	config     container.Config
+	hostConfig container.HostConfig
+	created    time.Time
+	started    time.Time
+	state      string
+	exitCode   int
+	runs       int
+	lines      []logEntry
+	networks   map[string]string
+	health     string
+	streak     int
+	anonymous  []string
+	healthLog  []*types.HealthcheckResult
+}
+
+// logEntry is a line of output of a container along with the time it was written.
+type logEntry struct {
+	Line
+	written time.Time
+}
+
+// endpoints returns the networks the container is attached to, by name, which keep the ID of the network they were
+// attached with even if it has been removed since.
+func (c *fakeContainer) endpoints() map[string]*network.EndpointSettings {
+	networks := make(map[string]*network.EndpointSettings, len(c.networks))
+	for name, id := range c.networks {
+		networks[name] = &network.EndpointSettings{NetworkID: id}
+	}
+
+	return networks
+}
+
+// maxHealthLog is the number of health check results Docker retains for a container.
+const maxHealthLog = 5
+
+// defaultHealthRetries is the number of consecutive failed health checks after which Docker considers a container
+// unhealthy, unless its health check configures Retries.
+const defaultHealthRetries = 3
+
+// hasHealthCheck returns whether the container is configured with a health check.
+func (c *fakeContainer) hasHealthCheck() bool {
+	check := c.config.Healthcheck
+	return check != nil && len(check.Test) > 0 && check.Test[0] != "NONE"
+}
+
+// findContainer looks up a container by ID, ID prefix or name. It must be called with the lock held.
+func (e *Engine) findContainer(idOrName string) (*fakeContainer, error) {
+	name := strings.TrimPrefix(idOrName, "/")
+	for _, c := range e.containers {
+		if c.id == idOrName || c.name == name {
+			return c, nil
+		}
+	}
+
+	if len(idOrName) > 0 {
+		for _, c := range e.containers {
+			if strings.HasPrefix(c.id, idOrName) {
+				return c, nil
+			}
+		}
+	}
+
+	return nil, NotFound("No such container: " + idOrName)
+}
+
+// ContainerState returns the state ("created", "running" or "exited") of the container with the given name or ID,
+// and whether such a container exists.
+func (e *Engine) ContainerState(idOrName string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.findContainer(idOrName)
+	if err != nil {
+		return "", false
+	}
+
+	return c.state, true
+}
+
+// SetHealth sets the health status ("starting", "healthy" or "unhealthy") that Docker reports for the container
+// with the given name or ID.
+func (e *Engine) SetHealth(idOrName string, status string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.findContainer(idOrName)
+	if err != nil {
+		return err
+	}
+
+	c.health = status
+	e.notify()
+	return nil
+}
+
+// RecordHealthCheck records the result of a health check of the container with the given name or ID, as if Docker
+// had run its health check command. A zero exit code makes the container healthy, while a non-zero exit code makes
+// it unhealthy once the number of consecutive failures reaches the Retries of its health check (3 by default).
+func (e *Engine) RecordHealthCheck(idOrName string, exitCode int, output string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.findContainer(idOrName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	c.healthLog = append(c.healthLog, &types.HealthcheckResult{Start: now, End: now, ExitCode: exitCode, Output: output})
+	if len(c.healthLog) > maxHealthLog {
+		c.healthLog = c.healthLog[len(c.healthLog)-maxHealthLog:]
+	}
+
+	retries := defaultHealthRetries
+	if c.config.Healthcheck != nil && c.config.Healthcheck.Retries > 0 {
+		retries = c.config.Healthcheck.Retries
+	}
+
+	if exitCode == 0 {
+		c.health, c.streak = types.Healthy, 0
+	} else {
+		c.streak += 1
+		if c.streak >= retries {
+			c.health = types.Unhealthy
+		} else if len(c.health) == 0 {
+			c.health = types.Starting
+		}
+	}
+
+	e.notify()
+	return nil
+}
+
+// Emit writes a line of output to the running container with the given name or ID.
+func (e *Engine) Emit(idOrName string, line Line) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.findContainer(idOrName)
+	if err != nil {
+		return err
+	}
+
+	if c.state != stateRunning {
+		return Conflict("container " + idOrName + " is not running")
+	}
+
+	c.lines = append(c.lines, logEntry{line, time.Now()})
+	e.notify()
+	return nil
+}
+
+// Exit stops the running container with the given name or ID using the provided exit code.
+func (e *Engine) Exit(idOrName string, exitCode int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.findContainer(idOrName)
+	if err != nil {
+		return err
+	}
+
+	if c.state != stateRunning {
+		return Conflict("container " + idOrName + " is not running")
+	}
+
+	e.exit(c, exitCode)
+	return nil
+}
+
+// exit transitions a container to the exited state. It must be called with the lock held.
+func (e *Engine) exit(c *fakeContainer, exitCode int) {
+	c.state = stateExited
+	c.exitCode = exitCode
+	e.stopReaper(c)
+	e.notify()
+}
+
+// run writes the lines of a Script to a container in the background.
+func (e *Engine) run(c *fakeContainer, run int, script Script) {
+	for _, line := range script.Lines {
+		if line.Delay > 0 {
+			time.Sleep(line.Delay)
+		}
+
+		e.mu.Lock()
+		if c.state != stateRunning || c.runs != run {
+			e.mu.Unlock()
+			return
+		}
+		c.lines = append(c.lines, logEntry{line, time.Now()})
+		e.notify()
+		e.mu.Unlock()
+	}
+
+	if !script.Exit {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c.state == stateRunning && c.runs == run {
+		e.exit(c, script.ExitCode)
+	}
+}
+
+// ContainerCreate creates a container for a locally available image.
+func (e *Engine) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.ContainerCreateCreatedBody, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerCreate"); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+
+	img := e.findImage(config.Image)
+	if img == nil {
+		return container.ContainerCreateCreatedBody{}, NotFound("No such image: " + config.Image)
+	}
+
+	if platform != nil && !platforms.NewMatcher(*platform).Match(img.platform) {
+		msg := fmt.Sprintf("image with reference %s was found but does not match the specified platform: wanted %s, actual: %s",
+			config.Image, platforms.Format(platforms.Normalize(*platform)), platforms.Format(img.platform))
+		return container.ContainerCreateCreatedBody{}, NotFound(msg)
+	}
+
+	id := e.newID("container")
+	if len(containerName) == 0 {
+		containerName = "fake_" + id[:12]
+	}
+
+	if existing, err := e.findContainer(containerName); err == nil {
+		msg := fmt.Sprintf("Conflict. The container name \"/%s\" is already in use by container \"%s\". "+
+			"You have to remove (or rename) that container to be able to reuse that name.", containerName, existing.id)
+		return container.ContainerCreateCreatedBody{}, Conflict(msg)
+	}
+
+	c := &fakeContainer{
+		id:       id,
+		name:     containerName,
+		imageID:  img.id,
+		config:   *config,
+		created:  time.Now(),
+		state:    stateCreated,
+		networks: make(map[string]string),
+	}
+	if hostConfig != nil {
+		c.hostConfig = *hostConfig
+		c.anonymous = e.createVolumes(hostConfig.Mounts)
+	}
+
+	e.containers[id] = c
+	e.notify()
+
+	return container.ContainerCreateCreatedBody{ID: id}, nil
+}
+
+// ContainerStart starts a container and begins running its Script.
+func (e *Engine) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerStart"); err != nil {
+		return err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+
+	if c.state == stateRunning {
+		return nil
+	}
+
+	// like Docker, a container can't be started while a network it is attached to no longer exists
+	for _, id := range c.networks {
+		if _, ok := e.networks[id]; !ok {
+			return NotFound("network " + id + " not found")
+		}
+	}
+
+	if isReaper(c) {
+		if err := e.startReaper(c); err != nil {
+			return err
+		}
+	}
+
+	c.state = stateRunning
+	c.started = time.Now()
+	c.exitCode = 0
+	c.runs += 1
+	if c.hasHealthCheck() {
+		c.health, c.streak, c.healthLog = types.Starting, 0, nil
+	}
+	e.notify()
+
+	go e.run(c, c.runs, e.scriptFor(c))
+	return nil
+}
+
+// ContainerStop stops a running container, which then exits with code 0.
+func (e *Engine) ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerStop"); err != nil {
+		return err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+
+	if c.state == stateRunning {
+		e.exit(c, 0)
+	}
+
+	return nil
+}
+
+// ContainerRemove removes a container, which must not be running unless Force is set.
+func (e *Engine) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerRemove"); err != nil {
+		return err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+
+	if c.state == stateRunning && !options.Force {
+		return Conflict("You cannot remove a running container " + c.id + ". Stop the container before attempting removal or force remove")
+	}
+
+	if c.state == stateRunning {
+		e.exit(c, 137)
+	}
+
+	for _, nw := range e.networks {
+		delete(nw.containers, c.id)
+	}
+
+	delete(e.containers, c.id)
+	if options.RemoveVolumes {
+		for _, name := range c.anonymous {
+			delete(e.volumes, name)
+		}
+	}
+	e.notify()
+	return nil
+}
+
+// ContainerWait waits until the container is no longer running, or has been removed.
+func (e *Engine) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	resultC := make(chan container.ContainerWaitOKBody, 1)
+	errC := make(chan error, 1)
+
+	e.mu.Lock()
+	err := e.begin("ContainerWait")
+	if err == nil {
+		_, err = e.findContainer(containerID)
+	}
+	e.mu.Unlock()
+
+	if err != nil {
+		errC <- err
+		return resultC, errC
+	}
+
+	go func() {
+		for {
+			e.mu.Lock()
+			c, err := e.findContainer(containerID)
+			running, exitCode := false, 0
+			if err == nil {
+				running, exitCode = c.state == stateRunning, c.exitCode
+			}
+			changed := e.changed
+			e.mu.Unlock()
+
+			switch {
+			case err != nil && condition == container.WaitConditionRemoved:
+				resultC <- container.ContainerWaitOKBody{}
+				return
+			case err != nil:
+				errC <- err
+				return
+			case condition != container.WaitConditionRemoved && !running:
+				resultC <- container.ContainerWaitOKBody{StatusCode: int64(exitCode)}
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				errC <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return resultC, errC
+}
+
+// logReader closes both the pipe and the goroutine that writes to it.
+type logReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r logReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// ContainerLogs returns the output of a container multiplexed in the same format Docker uses for containers
+// without a TTY, or as is for containers with a TTY. Since, if set, skips the lines written before it. When following,
+// the stream ends once the container is no longer running.
+func (e *Engine) ContainerLogs(ctx context.Context, containerID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerLogs"); err != nil {
+		return nil, err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if len(options.Since) > 0 {
+		// converted like the Docker SDK does before sending it to the daemon
+		ts, err := timetypes.GetTimestamp(options.Since, time.Now())
+		if err != nil {
+			return nil, errdefs.InvalidParameter(err)
+		}
+		sec, nsec, err := timetypes.ParseTimestamps(ts, 0)
+		if err != nil {
+			return nil, errdefs.InvalidParameter(err)
+		}
+		since = time.Unix(sec, nsec)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go e.writeLogs(ctx, c, options, since, pw)
+
+	return logReader{pr, cancel}, nil
+}
+
+func (e *Engine) writeLogs(ctx context.Context, c *fakeContainer, options types.ContainerLogsOptions, since time.Time, pw *io.PipeWriter) {
+	var stdout, stderr io.Writer = pw, pw
+	if !c.config.Tty {
+		stdout = stdcopy.NewStdWriter(pw, stdcopy.Stdout)
+		stderr = stdcopy.NewStdWriter(pw, stdcopy.Stderr)
+	}
+
+	next := 0
+	for {
+		e.mu.Lock()
+		lines := append([]logEntry(nil), c.lines[next:]...)
+		done := !options.Follow || c.state != stateRunning
+		changed := e.changed
+		e.mu.Unlock()
+
+		for _, line := range lines {
+			var err error
+			switch {
+			case line.written.Before(since):
+			case line.Stderr && options.ShowStderr:
+				_, err = stderr.Write([]byte(line.Text + "\n"))
+			case !line.Stderr && options.ShowStdout:
+				_, err = stdout.Write([]byte(line.Text + "\n"))
+			}
+
+			if err != nil {
+				return
+			}
+		}
+		next += len(lines)
+
+		if done {
+			_ = pw.Close()
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			_ = pw.CloseWithError(ctx.Err())
+			return
+		}
+	}
+}
+
+// ContainerList lists running containers, or all containers if All is set, that match the label and name filters.
+func (e *Engine) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerList"); err != nil {
+		return nil, err
+	}
+
+	var result []types.Container
+	for _, c := range e.containers {
+		if c.state != stateRunning && !options.All {
+			continue
+		}
+
+		if !matchesLabels(options.Filters, c.config.Labels) || !matchesName(options.Filters, "/"+c.name) {
+			continue
+		}
+
+		var mounts []types.MountPoint
+		for _, m := range c.hostConfig.Mounts {
+			mounts = append(mounts, types.MountPoint{
+				Type:        m.Type,
+				Source:      e.MountSourcePrefix + m.Source,
+				Destination: m.Target,
+				RW:          !m.ReadOnly,
+			})
+		}
+
+		result = append(result, types.Container{
+			ID:      c.id,
+			Names:   []string{"/" + c.name},
+			Image:   c.config.Image,
+			ImageID: c.imageID,
+			Command: strings.Join(c.config.Cmd, " "),
+			Created: c.created.Unix(),
+			Labels:  c.config.Labels,
+			State:   c.state,
+			Mounts:  mounts,
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: c.endpoints(),
+			},
+		})
+	}
+
+	return result, nil
+}
+
+// ContainerInspect returns low-level information about a container.
+func (e *Engine) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerInspect"); err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	return e.inspect(c), nil
+}
+
+// inspect builds the low-level information about a container. It must be called with the lock held.
+func (e *Engine) inspect(c *fakeContainer) types.ContainerJSON {
+	state := &types.ContainerState{
+		Status:   c.state,
+		Running:  c.state == stateRunning,
+		ExitCode: c.exitCode,
+	}
+	if !c.started.IsZero() {
+		state.StartedAt = c.started.Format(time.RFC3339Nano)
+	}
+	if len(c.health) > 0 {
+		state.Health = &types.Health{Status: c.health, FailingStreak: c.streak}
+		for _, result := range c.healthLog {
+			copied := *result
+			state.Health.Log = append(state.Health.Log, &copied)
+		}
+	}
+
+	ports := nat.PortMap{}
+	for port, bindings := range c.hostConfig.PortBindings {
+		ports[port] = append([]nat.PortBinding(nil), bindings...)
+	}
+
+	networks := c.endpoints()
+
+	var mounts []types.MountPoint
+	for _, m := range c.hostConfig.Mounts {
+		mounts = append(mounts, types.MountPoint{
+			Type:        m.Type,
+			Name:        volumeName(m),
+			Source:      e.MountSourcePrefix + m.Source,
+			Destination: m.Target,
+			RW:          !m.ReadOnly,
+		})
+	}
+
+	config := c.config
+	hostConfig := c.hostConfig
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.id,
+			Created:    c.created.Format(time.RFC3339Nano),
+			Name:       "/" + c.name,
+			Image:      c.imageID,
+			State:      state,
+			HostConfig: &hostConfig,
+		},
+		Mounts: mounts,
+		Config: &config,
+		NetworkSettings: &types.NetworkSettings{
+			NetworkSettingsBase: types.NetworkSettingsBase{Ports: ports},
+			Networks:            networks,
+		},
+	}
+}
+
+func volumeName(m mount.Mount) string {
+	if m.Type == mount.TypeVolume {
+		return m.Source
+	}
+
+	return ""
+}
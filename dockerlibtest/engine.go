@@ -0,0 +1,140 @@
+// Package dockerlibtest provides an in-memory stand-in for the Docker engine so that code built on
+// dockerlib.DockerController can be tested without a running Docker daemon.
+package dockerlibtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"sync"
+)
+
+var _ dockerlib.DockerAPI = (*Engine)(nil)
+
+// Engine is an in-memory fake of the Docker engine that implements dockerlib.DockerAPI. Containers, networks and
+// images only exist in memory, and container behaviour (log output, exit codes) is driven by Scripts.
+type Engine struct {
+	// MountSourcePrefix is prepended to the source of every mount reported by ContainerList, which mimics
+	// Docker Desktop reporting bind mounts under /host_mnt.
+	MountSourcePrefix string
+
+	// Host is the address reported by DaemonHost, which determines the host that published ports are reached on.
+	Host string
+
+	mu         sync.Mutex
+	changed    chan struct{}
+	nextID     int
+	images     map[string]*image
+	containers map[string]*fakeContainer
+	networks   map[string]*fakeNetwork
+	volumes    map[string]*fakeVolume
+	reapers    map[string]*fakeReaper
+	execs      map[string]*fakeExec
+	onExec     ExecHandler
+	scripts    map[string]Script
+	failures   map[string]error
+	pullErrors map[string]string
+	platforms  map[string][]specs.Platform
+	registries map[string]types.AuthConfig
+	builds     []Build
+	calls      map[string]int
+}
+
+// NewEngine creates an empty Engine without any images, containers or networks, apart from the predefined bridge,
+// host and none networks of Docker.
+func NewEngine() *Engine {
+	e := &Engine{
+		Host:       "unix:///var/run/docker.sock",
+		changed:    make(chan struct{}),
+		images:     make(map[string]*image),
+		containers: make(map[string]*fakeContainer),
+		networks:   make(map[string]*fakeNetwork),
+		volumes:    make(map[string]*fakeVolume),
+		reapers:    make(map[string]*fakeReaper),
+		execs:      make(map[string]*fakeExec),
+		scripts:    make(map[string]Script),
+		failures:   make(map[string]error),
+		pullErrors: make(map[string]string),
+		platforms:  make(map[string][]specs.Platform),
+		registries: make(map[string]types.AuthConfig),
+		calls:      make(map[string]int),
+	}
+
+	for _, name := range []string{"bridge", "host", "none"} {
+		nw := &fakeNetwork{id: e.newID("network"), name: name, predefined: true, containers: make(map[string]bool)}
+		e.networks[nw.id] = nw
+	}
+
+	return e
+}
+
+// Fail makes every subsequent call of the named operation (e.g. "ContainerCreate") return err. Passing a nil
+// error restores the normal behaviour of the operation.
+func (e *Engine) Fail(operation string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err == nil {
+		delete(e.failures, operation)
+		return
+	}
+
+	e.failures[operation] = err
+}
+
+// Calls returns how many times the named operation has been called.
+func (e *Engine) Calls(operation string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.calls[operation]
+}
+
+// DaemonHost returns the configured Host.
+func (e *Engine) DaemonHost() string {
+	return e.Host
+}
+
+// Conflict returns an error that the Docker SDK would classify as a conflict, such as a duplicate container name.
+func Conflict(msg string) error {
+	return errdefs.Conflict(fmt.Errorf("%s", msg))
+}
+
+// NotFound returns an error that the Docker SDK would classify as a missing object.
+func NotFound(msg string) error {
+	return errdefs.NotFound(fmt.Errorf("%s", msg))
+}
+
+// Forbidden returns an error that the Docker SDK would classify as a forbidden operation.
+func Forbidden(msg string) error {
+	return errdefs.Forbidden(fmt.Errorf("%s", msg))
+}
+
+// Unavailable returns an error that the Docker SDK would classify as the daemon (or registry) being unavailable.
+func Unavailable(msg string) error {
+	return errdefs.Unavailable(fmt.Errorf("%s", msg))
+}
+
+// begin records a call to the named operation and returns any configured failure. It must be called with the
+// lock held.
+func (e *Engine) begin(operation string) error {
+	e.calls[operation] += 1
+	return e.failures[operation]
+}
+
+// notify wakes up everything waiting for a change in state. It must be called with the lock held.
+func (e *Engine) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// newID generates a unique identifier that looks like a Docker object ID. It must be called with the lock held.
+func (e *Engine) newID(kind string) string {
+	e.nextID += 1
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", kind, e.nextID)))
+	return hex.EncodeToString(sum[:])
+}
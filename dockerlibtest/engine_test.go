@@ -0,0 +1,75 @@
+package dockerlibtest_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"testing"
+)
+
+func TestEngineLogsAndExit(t *testing.T) {
+	ctx := context.Background()
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+	engine.Script("alpine", dockerlibtest.Script{
+		Lines:    []dockerlibtest.Line{dockerlibtest.Stdout("out"), dockerlibtest.Stderr("err")},
+		Exit:     true,
+		ExitCode: 2,
+	})
+
+	created, err := engine.ContainerCreate(ctx, &container.Config{Image: "alpine"}, nil, nil, nil, "test")
+	if err != nil {
+		t.Fatalf("unexpected error when creating container: %v", err)
+	}
+
+	err = engine.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	okC, errC := engine.ContainerWait(ctx, "test", container.WaitConditionNotRunning)
+	select {
+	case result := <-okC:
+		if result.StatusCode != 2 {
+			t.Errorf("expected exit code 2, got %d", result.StatusCode)
+		}
+	case err := <-errC:
+		t.Fatalf("unexpected error when waiting for container: %v", err)
+	}
+
+	reader, err := engine.ContainerLogs(ctx, "test", types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		t.Fatalf("unexpected error when getting logs: %v", err)
+	}
+	defer reader.Close()
+
+	var stdout, stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(&stdout, &stderr, reader)
+	if err != nil {
+		t.Fatalf("unexpected error when demultiplexing logs: %v", err)
+	}
+
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("unexpected logs stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestEngineCreateConflict(t *testing.T) {
+	ctx := context.Background()
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+
+	_, err := engine.ContainerCreate(ctx, &container.Config{Image: "alpine"}, nil, nil, nil, "test")
+	if err != nil {
+		t.Fatalf("unexpected error when creating container: %v", err)
+	}
+
+	_, err = engine.ContainerCreate(ctx, &container.Config{Image: "alpine"}, nil, nil, nil, "test")
+	if !errdefs.IsConflict(err) {
+		t.Errorf("expected conflict error, got %v", err)
+	}
+}
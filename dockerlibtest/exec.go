@@ -0,0 +1,250 @@
+package dockerlibtest
+
+import (
+	"bufio"
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"net"
+	"time"
+)
+
+// Exec describes a command executed inside a fake container.
+type Exec struct {
+	Container string
+	Cmd       []string
+	Env       []string
+	User      string
+	Dir       string
+	// Stdin is the standard input of the command, if it was attached.
+	Stdin string
+	Tty   bool
+}
+
+// ExecResult is the outcome of an Exec. With a TTY, Stderr is reported as part of Stdout, like Docker does.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// ExecHandler decides the outcome of commands executed inside fake containers.
+type ExecHandler func(exec Exec) ExecResult
+
+type fakeExec struct {
+	id          string
+	exec        Exec
+	attachStdin bool
+	result      ExecResult
+	state       string
+}
+
+// OnExec registers the handler that decides the outcome of commands executed inside containers. Without a
+// handler every command exits with code 0 and no output.
+func (e *Engine) OnExec(handler ExecHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.onExec = handler
+}
+
+// ContainerExecCreate prepares a command to be executed inside a running container.
+func (e *Engine) ContainerExecCreate(ctx context.Context, containerID string, config types.ExecConfig) (types.IDResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerExecCreate"); err != nil {
+		return types.IDResponse{}, err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return types.IDResponse{}, err
+	}
+
+	if c.state != stateRunning {
+		return types.IDResponse{}, Conflict("Container " + c.id + " is not running")
+	}
+
+	exec := &fakeExec{
+		id:          e.newID("exec"),
+		exec:        Exec{Container: c.name, Cmd: config.Cmd, Env: config.Env, User: config.User, Dir: config.WorkingDir, Tty: config.Tty},
+		attachStdin: config.AttachStdin,
+		state:       stateCreated,
+	}
+	e.execs[exec.id] = exec
+
+	return types.IDResponse{ID: exec.id}, nil
+}
+
+// ContainerExecStart runs a previously created command to completion.
+func (e *Engine) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	e.mu.Lock()
+	exec, err := e.startExec("ContainerExecStart", execID)
+	handler := e.onExec
+	e.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	e.finishExec(exec, handler)
+	return nil
+}
+
+// ContainerExecAttach runs a previously created command, returning a connection that the standard input of the
+// command is read from, if it was attached, until CloseWrite is called, and its output is written to once it has
+// exited, multiplexed unless it has a TTY.
+func (e *Engine) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	e.mu.Lock()
+	exec, err := e.startExec("ContainerExecAttach", execID)
+	handler := e.onExec
+	e.mu.Unlock()
+
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	conn := &execConn{output: outputReader, stdin: stdinWriter}
+
+	go func() {
+		if exec.attachStdin {
+			stdin, _ := io.ReadAll(stdinReader)
+			e.mu.Lock()
+			exec.exec.Stdin = string(stdin)
+			e.mu.Unlock()
+		}
+		_ = stdinReader.Close()
+
+		result := e.finishExec(exec, handler)
+		if exec.exec.Tty {
+			_, _ = io.WriteString(outputWriter, result.Stdout+result.Stderr)
+		} else {
+			_, _ = io.WriteString(stdcopy.NewStdWriter(outputWriter, stdcopy.Stdout), result.Stdout)
+			_, _ = io.WriteString(stdcopy.NewStdWriter(outputWriter, stdcopy.Stderr), result.Stderr)
+		}
+		_ = outputWriter.Close()
+	}()
+
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+// execConn is the connection to an attached exec, which implements types.CloseWriter like a hijacked connection
+// to Docker.
+type execConn struct {
+	output *io.PipeReader
+	stdin  *io.PipeWriter
+}
+
+func (c *execConn) Read(p []byte) (int, error) {
+	return c.output.Read(p)
+}
+
+func (c *execConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *execConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+func (c *execConn) Close() error {
+	_ = c.stdin.Close()
+	return c.output.Close()
+}
+
+func (c *execConn) LocalAddr() net.Addr {
+	return execAddr{}
+}
+
+func (c *execConn) RemoteAddr() net.Addr {
+	return execAddr{}
+}
+
+func (c *execConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *execConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *execConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type execAddr struct{}
+
+func (execAddr) Network() string {
+	return "pipe"
+}
+
+func (execAddr) String() string {
+	return "exec"
+}
+
+// startExec marks a created exec as running. It must be called with the lock held.
+func (e *Engine) startExec(operation string, execID string) (*fakeExec, error) {
+	if err := e.begin(operation); err != nil {
+		return nil, err
+	}
+
+	exec, ok := e.execs[execID]
+	if !ok {
+		return nil, NotFound("No such exec instance: " + execID)
+	}
+
+	if exec.state != stateCreated {
+		return nil, Conflict("Error: Exec command " + execID + " has already run")
+	}
+
+	exec.state = stateRunning
+	return exec, nil
+}
+
+// finishExec runs the handler outside the lock, so that it can call back into the Engine, and records the result.
+func (e *Engine) finishExec(exec *fakeExec, handler ExecHandler) ExecResult {
+	var result ExecResult
+	if handler != nil {
+		result = handler(exec.exec)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exec.result = result
+	exec.state = stateExited
+	e.notify()
+	return result
+}
+
+// ContainerExecInspect returns the state of an exec.
+func (e *Engine) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ContainerExecInspect"); err != nil {
+		return types.ContainerExecInspect{}, err
+	}
+
+	exec, ok := e.execs[execID]
+	if !ok {
+		return types.ContainerExecInspect{}, NotFound("No such exec instance: " + execID)
+	}
+
+	c, _ := e.findContainer(exec.exec.Container)
+	var containerID string
+	if c != nil {
+		containerID = c.id
+	}
+
+	return types.ContainerExecInspect{
+		ExecID:      exec.id,
+		ContainerID: containerID,
+		Running:     exec.state == stateRunning,
+		ExitCode:    exec.result.ExitCode,
+	}, nil
+}
@@ -0,0 +1,535 @@
+package dockerlibtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// imageLayerSize is the size of every layer of a pulled image, as reported by the pull progress.
+const imageLayerSize = 1024
+
+// defaultPlatform is the platform of the fake Docker host, which images are pulled for unless another platform is
+// requested.
+var defaultPlatform = specs.Platform{OS: "linux", Architecture: "amd64"}
+
+type image struct {
+	id       string
+	digest   string
+	refs     []string
+	config   *container.Config
+	size     int64
+	created  time.Time
+	platform specs.Platform
+}
+
+// configCopy returns a copy of the configuration of the image that can be modified.
+func (img *image) configCopy() *container.Config {
+	config := &container.Config{Labels: make(map[string]string)}
+	if img.config == nil {
+		return config
+	}
+
+	config.Cmd = append(config.Cmd, img.config.Cmd...)
+	config.Env = append(config.Env, img.config.Env...)
+	for key, value := range img.config.Labels {
+		config.Labels[key] = value
+	}
+	if img.config.ExposedPorts != nil {
+		config.ExposedPorts = make(nat.PortSet, len(img.config.ExposedPorts))
+		for port := range img.config.ExposedPorts {
+			config.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	return config
+}
+
+// repoDigests returns the references of the image by digest, one for every repository it is known by. Images
+// that were built locally don't have a digest until they are pushed.
+func (img *image) repoDigests() []string {
+	if len(img.digest) == 0 {
+		return nil
+	}
+
+	var digests []string
+	seen := make(map[string]bool)
+	for _, ref := range img.refs {
+		repo := repository(ref)
+		if seen[repo] {
+			continue
+		}
+
+		seen[repo] = true
+		digests = append(digests, repo+"@"+img.digest)
+	}
+
+	return digests
+}
+
+// tags returns the references of the image by tag.
+func (img *image) tags() []string {
+	var tags []string
+	for _, ref := range img.refs {
+		if !strings.Contains(ref, "@") {
+			tags = append(tags, ref)
+		}
+	}
+
+	return tags
+}
+
+// matches returns whether the image is known by the given image ID, reference by tag or reference by digest.
+func (img *image) matches(ref string) bool {
+	if ref == img.id || ref == strings.TrimPrefix(img.id, "sha256:") {
+		return true
+	}
+
+	key := normalize(ref)
+	for _, r := range img.refs {
+		if r == key {
+			return true
+		}
+	}
+
+	for _, digest := range img.repoDigests() {
+		if digest == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalize converts an image reference to the short form Docker uses when displaying it, adding the latest tag
+// when no tag or digest is specified.
+func normalize(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// repository returns the short form of the repository of an image reference, without its tag or digest.
+func repository(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+
+	return reference.FamiliarName(named)
+}
+
+// AddImage makes the given image references available locally, as if they had already been pulled. A reference
+// by digest (e.g. alpine@sha256:...) adds an image with that digest.
+func (e *Engine) AddImage(refs ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ref := range refs {
+		e.addImage(ref)
+	}
+}
+
+// HasImage returns whether the given image reference or ID is available locally.
+func (e *Engine) HasImage(ref string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.findImage(ref) != nil
+}
+
+// findImage returns the image known by the given reference or ID, or nil if there isn't one. It must be called
+// with the lock held.
+func (e *Engine) findImage(ref string) *image {
+	for _, img := range e.images {
+		if img.matches(ref) {
+			return img
+		}
+	}
+
+	return nil
+}
+
+// AddImageForPlatform makes the given image references available locally for the given platform (e.g.
+// linux/arm64), as if they had been pulled for it. It panics if the platform is invalid.
+func (e *Engine) AddImageForPlatform(platform string, refs ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ref := range refs {
+		e.addPlatformImage(ref, platforms.MustParse(platform))
+	}
+}
+
+// SetPlatforms restricts the platforms (e.g. linux/arm64) the image reference can be pulled for, as if the registry
+// only had those variants of the image. By default an image can be pulled for any platform. It panics if a platform
+// is invalid.
+func (e *Engine) SetPlatforms(ref string, available ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var parsed []specs.Platform
+	for _, platform := range available {
+		parsed = append(parsed, platforms.MustParse(platform))
+	}
+
+	e.platforms[normalize(ref)] = parsed
+}
+
+// addImage registers an image reference for the default platform. It must be called with the lock held.
+func (e *Engine) addImage(ref string) *image {
+	return e.addPlatformImage(ref, defaultPlatform)
+}
+
+// addPlatformImage registers an image reference for the given platform, replacing the image it refers to if that
+// is for another platform. It must be called with the lock held.
+func (e *Engine) addPlatformImage(ref string, platform specs.Platform) *image {
+	if img := e.findImage(ref); img != nil && platforms.NewMatcher(platform).Match(img.platform) {
+		return img
+	}
+
+	key := normalize(ref)
+	img := &image{
+		id:       "sha256:" + e.newID("image"),
+		size:     2 * imageLayerSize,
+		created:  time.Now(),
+		platform: platforms.Normalize(platform),
+	}
+	e.tagImage(img, key)
+
+	if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+		if canonical, ok := named.(reference.Canonical); ok {
+			img.digest = canonical.Digest().String()
+		}
+	}
+	if len(img.digest) == 0 {
+		img.digest = "sha256:" + e.newID("digest")
+	}
+
+	e.images[img.id] = img
+	return img
+}
+
+// tagImage adds a reference to the image, removing it from any other image that had it. It must be called with the
+// lock held.
+func (e *Engine) tagImage(img *image, ref string) {
+	key := normalize(ref)
+	for _, other := range e.images {
+		for i, r := range other.refs {
+			if r == key {
+				other.refs = append(other.refs[:i], other.refs[i+1:]...)
+				break
+			}
+		}
+	}
+
+	img.refs = append(img.refs, key)
+}
+
+// ImageInspectWithRaw returns information about a locally available image.
+func (e *Engine) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImageInspectWithRaw"); err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+
+	img := e.findImage(imageID)
+	if img == nil {
+		return types.ImageInspect{}, nil, NotFound("No such image: " + imageID)
+	}
+
+	inspect := types.ImageInspect{
+		ID:           img.id,
+		RepoTags:     img.tags(),
+		RepoDigests:  img.repoDigests(),
+		Os:           img.platform.OS,
+		Architecture: img.platform.Architecture,
+		Variant:      img.platform.Variant,
+		Config:       img.configCopy(),
+		Size:         img.size,
+		VirtualSize:  img.size,
+		Created:      img.created.Format(time.RFC3339Nano),
+	}
+
+	raw, err := json.Marshal(inspect)
+	return inspect, raw, err
+}
+
+// FailPull makes subsequent pulls of the given image reference report msg in the progress stream after they have
+// started, which is how Docker reports failures such as being denied access to a layer. Passing an empty msg
+// restores the normal behaviour of the pull.
+func (e *Engine) FailPull(ref string, msg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if msg == "" {
+		delete(e.pullErrors, normalize(ref))
+		return
+	}
+
+	e.pullErrors[normalize(ref)] = msg
+}
+
+// RequireAuth makes pulls of images hosted by the given registry (e.g. docker.io or registry.example.com:5000)
+// fail unless they are made with the given username and password, like a private registry.
+func (e *Engine) RequireAuth(registry string, username string, password string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.registries[registry] = types.AuthConfig{Username: username, Password: password}
+}
+
+// authorize checks the credentials of a pull against those required by the registry of the image. It must be
+// called with the lock held.
+func (e *Engine) authorize(ref string, encoded string) error {
+	registry := "docker.io"
+	if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+		registry = reference.Domain(named)
+	}
+
+	required, ok := e.registries[registry]
+	if !ok {
+		return nil
+	}
+
+	var auth types.AuthConfig
+	if decoded, err := base64.URLEncoding.DecodeString(encoded); err == nil {
+		_ = json.Unmarshal(decoded, &auth)
+	}
+
+	if auth.Username != required.Username || auth.Password != required.Password {
+		return errdefs.Unauthorized(fmt.Errorf("pull access denied for %s: unauthorized: authentication required", repository(ref)))
+	}
+
+	return nil
+}
+
+// ImagePull makes the image available locally and returns a progress stream similar to the one sent by Docker.
+func (e *Engine) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImagePull"); err != nil {
+		return nil, err
+	}
+
+	if err := e.authorize(ref, options.RegistryAuth); err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	_ = encoder.Encode(map[string]interface{}{"status": "Pulling from " + repository(ref), "id": "latest"})
+
+	platform := defaultPlatform
+	if len(options.Platform) > 0 {
+		parsed, err := platforms.Parse(options.Platform)
+		if err != nil {
+			return nil, errdefs.InvalidParameter(err)
+		}
+		platform = parsed
+	}
+
+	msg, ok := e.pullErrors[normalize(ref)]
+	if available, restricted := e.platforms[normalize(ref)]; !ok && restricted && !platforms.Any(available...).Match(platform) {
+		msg, ok = fmt.Sprintf("no matching manifest for %s in the manifest list entries", platforms.Format(platform)), true
+	}
+	if ok {
+		_ = encoder.Encode(map[string]interface{}{"errorDetail": map[string]interface{}{"message": msg}, "error": msg})
+		return ioutil.NopCloser(&buffer), nil
+	}
+
+	img := e.addPlatformImage(ref, platform)
+	layers := []string{img.id[7:19], img.id[19:31]}
+
+	var messages []map[string]interface{}
+	for _, layer := range layers {
+		messages = append(messages, map[string]interface{}{"status": "Pulling fs layer", "id": layer})
+	}
+	for _, layer := range layers {
+		messages = append(messages,
+			map[string]interface{}{"status": "Downloading", "id": layer, "progress": "[=====>     ] 512B/1.024kB",
+				"progressDetail": map[string]int{"current": 512, "total": 1024}},
+			map[string]interface{}{"status": "Download complete", "id": layer},
+			map[string]interface{}{"status": "Extracting", "id": layer, "progress": "[==========>] 1.024kB/1.024kB",
+				"progressDetail": map[string]int{"current": 1024, "total": 1024}},
+			map[string]interface{}{"status": "Pull complete", "id": layer},
+		)
+	}
+	messages = append(messages,
+		map[string]interface{}{"status": "Digest: " + img.digest},
+		map[string]interface{}{"status": fmt.Sprintf("Status: Downloaded newer image for %s", normalize(ref))},
+	)
+	for _, msg := range messages {
+		_ = encoder.Encode(msg)
+	}
+
+	return ioutil.NopCloser(&buffer), nil
+}
+
+// ImageTag adds the target reference to the source image, moving it from any other image that had it.
+func (e *Engine) ImageTag(ctx context.Context, source, target string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImageTag"); err != nil {
+		return err
+	}
+
+	img := e.findImage(source)
+	if img == nil {
+		return NotFound("No such image: " + source)
+	}
+
+	if img.matches(target) {
+		return nil
+	}
+
+	e.tagImage(img, target)
+	return nil
+}
+
+// ImageRemove removes a reference to an image, and the image itself once it has no references left. An image
+// with multiple references is only removed if forced, and an image used by a container is never removed unless
+// forced and the container isn't running.
+func (e *Engine) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImageRemove"); err != nil {
+		return nil, err
+	}
+
+	img := e.findImage(imageID)
+	if img == nil {
+		return nil, NotFound("No such image: " + imageID)
+	}
+
+	byID := imageID == img.id || strings.HasPrefix(img.id, "sha256:"+imageID)
+	tags := img.tags()
+	if !byID && len(tags) > 1 && !options.Force {
+		key := normalize(imageID)
+		e.untagImage(img, key)
+		return []types.ImageDeleteResponseItem{{Untagged: key}}, nil
+	}
+
+	if byID && len(tags) > 1 && !options.Force {
+		return nil, Conflict(fmt.Sprintf("conflict: unable to delete %s (must be forced) - image is referenced in multiple repositories", shortID(img.id)))
+	}
+
+	for _, c := range e.containers {
+		if c.imageID != img.id {
+			continue
+		}
+
+		if !options.Force || c.state == stateRunning {
+			return nil, Conflict(fmt.Sprintf("conflict: unable to delete %s - image is being used by container %s", shortID(img.id), shortID(c.id)))
+		}
+	}
+
+	return e.deleteImage(img), nil
+}
+
+// ImagesPrune removes the images that aren't used by any container and match the filters. Only dangling
+// (untagged) images are removed unless the dangling filter is false, and label and label! filters are supported.
+func (e *Engine) ImagesPrune(ctx context.Context, pruneFilters filters.Args) (types.ImagesPruneReport, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImagesPrune"); err != nil {
+		return types.ImagesPruneReport{}, err
+	}
+
+	danglingOnly := !pruneFilters.Contains("dangling") || pruneFilters.ExactMatch("dangling", "true") || pruneFilters.ExactMatch("dangling", "1")
+
+	used := make(map[string]bool)
+	for _, c := range e.containers {
+		used[c.imageID] = true
+	}
+
+	var report types.ImagesPruneReport
+	for _, img := range e.images {
+		if used[img.id] || danglingOnly && len(img.tags()) > 0 {
+			continue
+		}
+
+		var labels map[string]string
+		if img.config != nil {
+			labels = img.config.Labels
+		}
+		if !matchesLabels(pruneFilters, labels) {
+			continue
+		}
+
+		report.ImagesDeleted = append(report.ImagesDeleted, e.deleteImage(img)...)
+		report.SpaceReclaimed += uint64(img.size)
+	}
+
+	return report, nil
+}
+
+// matchesLabels returns whether labels satisfy the label and label! filters.
+func matchesLabels(args filters.Args, labels map[string]string) bool {
+	if args.Contains("label") && !args.MatchKVList("label", labels) {
+		return false
+	}
+
+	for _, label := range args.Get("label!") {
+		parts := strings.SplitN(label, "=", 2)
+		value, ok := labels[parts[0]]
+		if ok && (len(parts) == 1 || parts[1] == value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesName returns whether the name matches the name filters, which like Docker's are regular expressions that
+// only need to match part of the name.
+func matchesName(args filters.Args, name string) bool {
+	return !args.Contains("name") || args.Match("name", name)
+}
+
+// untagImage removes a reference from an image. It must be called with the lock held.
+func (e *Engine) untagImage(img *image, ref string) {
+	for i, r := range img.refs {
+		if r == ref {
+			img.refs = append(img.refs[:i], img.refs[i+1:]...)
+			return
+		}
+	}
+}
+
+// deleteImage removes an image along with all of its references. It must be called with the lock held.
+func (e *Engine) deleteImage(img *image) []types.ImageDeleteResponseItem {
+	var items []types.ImageDeleteResponseItem
+	for _, ref := range img.tags() {
+		items = append(items, types.ImageDeleteResponseItem{Untagged: ref})
+	}
+	for _, ref := range img.repoDigests() {
+		items = append(items, types.ImageDeleteResponseItem{Untagged: ref})
+	}
+
+	delete(e.images, img.id)
+	return append(items, types.ImageDeleteResponseItem{Deleted: img.id})
+}
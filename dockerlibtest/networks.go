@@ -0,0 +1,149 @@
+package dockerlibtest
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+type fakeNetwork struct {
+	id         string
+	name       string
+	labels     map[string]string
+	containers map[string]bool
+	// predefined is set for the networks Docker creates itself, which can't be removed.
+	predefined bool
+}
+
+// findNetwork looks up a network by ID or name. It must be called with the lock held.
+func (e *Engine) findNetwork(idOrName string) (*fakeNetwork, error) {
+	for _, nw := range e.networks {
+		if nw.id == idOrName || nw.name == idOrName {
+			return nw, nil
+		}
+	}
+
+	return nil, NotFound("network " + idOrName + " not found")
+}
+
+// NetworkExists returns whether a network with the given name or ID exists.
+func (e *Engine) NetworkExists(idOrName string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.findNetwork(idOrName)
+	return err == nil
+}
+
+// NetworkList lists the networks matching the label and name filters.
+func (e *Engine) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("NetworkList"); err != nil {
+		return nil, err
+	}
+
+	var result []types.NetworkResource
+	for _, nw := range e.networks {
+		if matchesLabels(options.Filters, nw.labels) && matchesName(options.Filters, nw.name) {
+			result = append(result, nw.resource())
+		}
+	}
+
+	return result, nil
+}
+
+// NetworkCreate creates a bridge network.
+func (e *Engine) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("NetworkCreate"); err != nil {
+		return types.NetworkCreateResponse{}, err
+	}
+
+	if _, err := e.findNetwork(name); err == nil && options.CheckDuplicate {
+		return types.NetworkCreateResponse{}, Conflict("network with name " + name + " already exists")
+	}
+
+	nw := &fakeNetwork{
+		id:         e.newID("network"),
+		name:       name,
+		labels:     options.Labels,
+		containers: make(map[string]bool),
+	}
+	e.networks[nw.id] = nw
+	e.notify()
+
+	return types.NetworkCreateResponse{ID: nw.id}, nil
+}
+
+// NetworkConnect attaches a container to a network.
+func (e *Engine) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("NetworkConnect"); err != nil {
+		return err
+	}
+
+	nw, err := e.findNetwork(networkID)
+	if err != nil {
+		return err
+	}
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+
+	nw.containers[c.id] = true
+	c.networks[nw.name] = nw.id
+	e.notify()
+	return nil
+}
+
+// NetworkRemove removes a network, which must not have any running containers attached.
+func (e *Engine) NetworkRemove(ctx context.Context, networkID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("NetworkRemove"); err != nil {
+		return err
+	}
+
+	nw, err := e.findNetwork(networkID)
+	if err != nil {
+		return err
+	}
+
+	if nw.predefined {
+		return Forbidden(nw.name + " is a pre-defined network and cannot be removed")
+	}
+
+	for id := range nw.containers {
+		if c, ok := e.containers[id]; ok && c.state == stateRunning {
+			return Forbidden("error while removing network: network " + nw.name + " id " + nw.id + " has active endpoints")
+		}
+	}
+
+	delete(e.networks, nw.id)
+	e.notify()
+	return nil
+}
+
+func (nw *fakeNetwork) resource() types.NetworkResource {
+	containers := make(map[string]types.EndpointResource, len(nw.containers))
+	for id := range nw.containers {
+		containers[id] = types.EndpointResource{}
+	}
+
+	return types.NetworkResource{
+		Name:       nw.name,
+		ID:         nw.id,
+		Driver:     "bridge",
+		Labels:     nw.labels,
+		Containers: containers,
+	}
+}
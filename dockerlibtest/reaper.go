@@ -0,0 +1,155 @@
+package dockerlibtest
+
+import (
+	"bufio"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-connections/nat"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// reaperPort is the port the reaper container accepts connections on.
+const reaperPort = nat.Port("8080/tcp")
+
+// fakeReaper emulates a container running dockerlib.ReaperImage. It accepts connections on a local port published
+// as the reaper's port, acknowledges the filters sent on them, and once every connection has been closed removes
+// the containers, networks and volumes matching any of the filters before exiting, like Testcontainers' Ryuk.
+type fakeReaper struct {
+	listener net.Listener
+	filters  []filters.Args
+	conns    int
+}
+
+// isReaper returns whether the container runs the reaper image.
+func isReaper(c *fakeContainer) bool {
+	return repository(c.config.Image) == repository(dockerlib.ReaperImage)
+}
+
+// startReaper starts listening for connections to the reaper container. It must be called with the lock held.
+func (e *Engine) startReaper(c *fakeContainer) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	if c.hostConfig.PortBindings == nil {
+		c.hostConfig.PortBindings = nat.PortMap{}
+	}
+	c.hostConfig.PortBindings[reaperPort] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: port}}
+
+	reaper := &fakeReaper{listener: listener}
+	e.reapers[c.id] = reaper
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			e.mu.Lock()
+			reaper.conns += 1
+			e.mu.Unlock()
+
+			go e.serveReaper(c, reaper, conn)
+		}
+	}()
+
+	return nil
+}
+
+// serveReaper reads the filters sent on a connection to the reaper, one set per line in URL query format (e.g.
+// label=dockerlib.session%3D...), acknowledging each of them. The resources are reaped once the last connection
+// is closed.
+func (e *Engine) serveReaper(c *fakeContainer, reaper *fakeReaper, conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		query, err := url.ParseQuery(scanner.Text())
+		if err != nil {
+			continue
+		}
+
+		args := filters.NewArgs()
+		for key, values := range query {
+			for _, value := range values {
+				args.Add(key, value)
+			}
+		}
+
+		e.mu.Lock()
+		reaper.filters = append(reaper.filters, args)
+		e.mu.Unlock()
+
+		_, _ = conn.Write([]byte("ACK\n"))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	reaper.conns -= 1
+	if reaper.conns > 0 || e.reapers[c.id] != reaper {
+		return
+	}
+
+	e.reap(reaper.filters)
+	e.exit(c, 0)
+	if c.hostConfig.AutoRemove {
+		delete(e.containers, c.id)
+	}
+	e.notify()
+}
+
+// reap removes the containers, networks and volumes matching any of the filters. It must be called with the lock
+// held.
+func (e *Engine) reap(args []filters.Args) {
+	matches := func(labels map[string]string) bool {
+		for _, arg := range args {
+			if matchesLabels(arg, labels) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for id, c := range e.containers {
+		if !matches(c.config.Labels) {
+			continue
+		}
+
+		if c.state == stateRunning {
+			e.exit(c, 137)
+		}
+		for _, nw := range e.networks {
+			delete(nw.containers, id)
+		}
+		for _, name := range c.anonymous {
+			delete(e.volumes, name)
+		}
+		delete(e.containers, id)
+	}
+
+	for id, nw := range e.networks {
+		if matches(nw.labels) && len(nw.containers) == 0 {
+			delete(e.networks, id)
+		}
+	}
+
+	for name, v := range e.volumes {
+		if matches(v.labels) && !e.volumeInUse(name) {
+			delete(e.volumes, name)
+		}
+	}
+}
+
+// stopReaper stops listening for connections to the container if it is a reaper. It must be called with the lock
+// held.
+func (e *Engine) stopReaper(c *fakeContainer) {
+	if reaper, ok := e.reapers[c.id]; ok {
+		_ = reaper.listener.Close()
+		delete(e.reapers, c.id)
+	}
+}
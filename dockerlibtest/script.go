@@ -0,0 +1,58 @@
+package dockerlibtest
+
+import (
+	"time"
+)
+
+// Line is a single line of output written by a fake container.
+type Line struct {
+	Text   string
+	Stderr bool
+	Delay  time.Duration
+}
+
+// Stdout is a helper to create a Line written to standard output.
+func Stdout(text string) Line {
+	return Line{Text: text}
+}
+
+// Stderr is a helper to create a Line written to standard error.
+func Stderr(text string) Line {
+	return Line{Text: text, Stderr: true}
+}
+
+// After returns a copy of the Line that is only written once the given delay has passed.
+func (l Line) After(delay time.Duration) Line {
+	l.Delay = delay
+	return l
+}
+
+// Script describes how a fake container behaves once it has been started. Lines are written in order, and if
+// Exit is set the container stops with ExitCode afterwards. Otherwise it keeps running until it is stopped.
+type Script struct {
+	Lines    []Line
+	Exit     bool
+	ExitCode int
+}
+
+// Script registers the behaviour for containers created with the given name or image. Scripts registered for a
+// container name take precedence over scripts registered for an image.
+func (e *Engine) Script(nameOrImage string, script Script) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.scripts[nameOrImage] = script
+}
+
+// scriptFor finds the Script for a container. It must be called with the lock held.
+func (e *Engine) scriptFor(c *fakeContainer) Script {
+	if script, ok := e.scripts[c.name]; ok {
+		return script
+	}
+
+	if script, ok := e.scripts[c.config.Image]; ok {
+		return script
+	}
+
+	return e.scripts[normalize(c.config.Image)]
+}
@@ -0,0 +1,501 @@
+package dockerlibtest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion is the Docker Engine API version reported by Server.
+const APIVersion = "1.41"
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// Server speaks enough of the Docker Engine REST API for the Docker SDK client to be pointed at it (e.g. via
+// DOCKER_HOST) while every request is served by a backend such as an Engine.
+type Server struct {
+	*httptest.Server
+	backend dockerlib.DockerAPI
+	host    string
+}
+
+// NewServer starts a Server listening on a local TCP port. It should be closed once it is no longer needed.
+func NewServer(backend dockerlib.DockerAPI) *Server {
+	s := &Server{backend: backend}
+	s.Server = httptest.NewServer(s)
+	s.host = "tcp://" + s.Listener.Addr().String()
+	return s
+}
+
+// NewUnixServer starts a Server listening on a unix socket at the provided path. It should be closed once it is no
+// longer needed.
+func NewUnixServer(backend dockerlib.DockerAPI, socketPath string) (*Server, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{backend: backend, host: "unix://" + socketPath}
+	s.Server = httptest.NewUnstartedServer(s)
+	_ = s.Listener.Close()
+	s.Listener = listener
+	s.Start()
+	return s, nil
+}
+
+// Host returns the address of the Server in the format expected by DOCKER_HOST.
+func (s *Server) Host() string {
+	return s.host
+}
+
+// ServeHTTP routes a Docker Engine API request to the backend.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	w.Header().Set("Api-Version", APIVersion)
+	w.Header().Set("Ostype", "linux")
+
+	switch {
+	case parts[0] == "_ping":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "OK")
+	case r.Method == http.MethodGet && path == "/version":
+		writeJSON(w, http.StatusOK, types.Version{APIVersion: APIVersion, Version: "20.10.13-fake", Os: "linux", Arch: "amd64"})
+	case r.Method == http.MethodPost && path == "/images/create":
+		s.imagePull(w, r)
+	case r.Method == http.MethodPost && path == "/build":
+		s.imageBuild(w, r)
+	case r.Method == http.MethodGet && path == "/images/get":
+		reader, err := s.backend.ImageSave(r.Context(), r.URL.Query()["names"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer reader.Close()
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.WriteHeader(http.StatusOK)
+		copyFlushing(w, reader)
+	case r.Method == http.MethodPost && path == "/images/load":
+		resp, err := s.backend.ImageLoad(r.Context(), r.Body, boolValue(r.URL.Query().Get("quiet")))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		copyFlushing(w, resp.Body)
+	case r.Method == http.MethodPost && path == "/images/prune":
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+		report, err := s.backend.ImagesPrune(r.Context(), args)
+		respond(w, http.StatusOK, report, err)
+	case r.Method == http.MethodPost && parts[0] == "images" && len(parts) > 2 && parts[len(parts)-1] == "tag":
+		target := r.URL.Query().Get("repo")
+		if tag := r.URL.Query().Get("tag"); len(tag) > 0 {
+			target += ":" + tag
+		}
+		err := s.backend.ImageTag(r.Context(), strings.Join(parts[1:len(parts)-1], "/"), target)
+		respond(w, http.StatusCreated, nil, err)
+	case r.Method == http.MethodDelete && parts[0] == "images" && len(parts) > 1:
+		options := types.ImageRemoveOptions{
+			Force:         boolValue(r.URL.Query().Get("force")),
+			PruneChildren: !boolValue(r.URL.Query().Get("noprune")),
+		}
+		items, err := s.backend.ImageRemove(r.Context(), strings.Join(parts[1:], "/"), options)
+		respond(w, http.StatusOK, items, err)
+	case r.Method == http.MethodGet && parts[0] == "images" && len(parts) > 2 && parts[len(parts)-1] == "json":
+		// image names may contain slashes, e.g. /images/library/alpine:latest/json
+		_, raw, err := s.backend.ImageInspectWithRaw(r.Context(), strings.Join(parts[1:len(parts)-1], "/"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(raw)
+	case parts[0] == "containers":
+		s.containers(w, r, parts[1:])
+	case parts[0] == "networks":
+		s.networks(w, r, parts[1:])
+	case parts[0] == "exec":
+		s.exec(w, r, parts[1:])
+	case r.Method == http.MethodGet && path == "/volumes":
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+		list, err := s.backend.VolumeList(r.Context(), args)
+		respond(w, http.StatusOK, list, err)
+	case r.Method == http.MethodDelete && parts[0] == "volumes" && len(parts) == 2:
+		err := s.backend.VolumeRemove(r.Context(), parts[1], boolValue(r.URL.Query().Get("force")))
+		respond(w, http.StatusNoContent, nil, err)
+	default:
+		writeError(w, errdefs.NotFound(errorString("page not found: "+r.Method+" "+r.URL.Path)))
+	}
+}
+
+func (s *Server) containers(w http.ResponseWriter, r *http.Request, parts []string) {
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "json":
+		args, err := filters.FromJSON(query.Get("filters"))
+		if err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+
+		list, err := s.backend.ContainerList(r.Context(), types.ContainerListOptions{All: boolValue(query.Get("all")), Filters: args})
+		respond(w, http.StatusOK, list, err)
+
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "create":
+		var body struct {
+			*container.Config
+			HostConfig *container.HostConfig
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+		if body.Config == nil {
+			body.Config = &container.Config{}
+		}
+
+		var platform *specs.Platform
+		if len(query.Get("platform")) > 0 {
+			parsed, err := platforms.Parse(query.Get("platform"))
+			if err != nil {
+				writeError(w, errdefs.InvalidParameter(err))
+				return
+			}
+			platform = &parsed
+		}
+
+		created, err := s.backend.ContainerCreate(r.Context(), body.Config, body.HostConfig, nil, platform, query.Get("name"))
+		respond(w, http.StatusCreated, created, err)
+
+	case r.Method == http.MethodDelete && len(parts) == 1:
+		options := types.ContainerRemoveOptions{
+			RemoveVolumes: boolValue(query.Get("v")),
+			Force:         boolValue(query.Get("force")),
+		}
+		respond(w, http.StatusNoContent, nil, s.backend.ContainerRemove(r.Context(), parts[0], options))
+
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "json":
+		info, err := s.backend.ContainerInspect(r.Context(), parts[0])
+		respond(w, http.StatusOK, info, err)
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "exec":
+		var body types.ExecConfig
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+
+		created, err := s.backend.ContainerExecCreate(r.Context(), parts[0], body)
+		respond(w, http.StatusCreated, created, err)
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "start":
+		respond(w, http.StatusNoContent, nil, s.backend.ContainerStart(r.Context(), parts[0], types.ContainerStartOptions{}))
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "stop":
+		var timeout *time.Duration
+		if seconds, err := strconv.Atoi(query.Get("t")); err == nil {
+			d := time.Duration(seconds) * time.Second
+			timeout = &d
+		}
+		respond(w, http.StatusNoContent, nil, s.backend.ContainerStop(r.Context(), parts[0], timeout))
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "wait":
+		s.containerWait(w, r, parts[0])
+
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "logs":
+		options := types.ContainerLogsOptions{
+			ShowStdout: boolValue(query.Get("stdout")),
+			ShowStderr: boolValue(query.Get("stderr")),
+			Follow:     boolValue(query.Get("follow")),
+			Since:      query.Get("since"),
+			Tail:       query.Get("tail"),
+		}
+		reader, err := s.backend.ContainerLogs(r.Context(), parts[0], options)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer reader.Close()
+
+		w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		w.WriteHeader(http.StatusOK)
+		copyFlushing(w, reader)
+
+	default:
+		writeError(w, errdefs.NotFound(errorString("page not found: "+r.Method+" "+r.URL.Path)))
+	}
+}
+
+func (s *Server) containerWait(w http.ResponseWriter, r *http.Request, id string) {
+	condition := container.WaitCondition(r.URL.Query().Get("condition"))
+	if len(condition) == 0 {
+		condition = container.WaitConditionNotRunning
+	}
+
+	okC, errC := s.backend.ContainerWait(r.Context(), id, condition)
+
+	// Docker reports errors that happen before waiting (e.g. unknown container) as a status code, otherwise the
+	// headers are sent straight away and the result follows once the container stops.
+	select {
+	case err := <-errC:
+		writeError(w, err)
+		return
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	select {
+	case err := <-errC:
+		_ = json.NewEncoder(w).Encode(container.ContainerWaitOKBody{
+			StatusCode: -1,
+			Error:      &container.ContainerWaitOKBodyError{Message: err.Error()},
+		})
+	case result := <-okC:
+		_ = json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *Server) networks(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 0:
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+
+		list, err := s.backend.NetworkList(r.Context(), types.NetworkListOptions{Filters: args})
+		respond(w, http.StatusOK, list, err)
+
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "create":
+		var body types.NetworkCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+
+		created, err := s.backend.NetworkCreate(r.Context(), body.Name, body.NetworkCreate)
+		respond(w, http.StatusCreated, created, err)
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "connect":
+		var body types.NetworkConnect
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+
+		err := s.backend.NetworkConnect(r.Context(), parts[0], body.Container, body.EndpointConfig)
+		respond(w, http.StatusOK, nil, err)
+
+	case r.Method == http.MethodDelete && len(parts) == 1:
+		respond(w, http.StatusNoContent, nil, s.backend.NetworkRemove(r.Context(), parts[0]))
+
+	default:
+		writeError(w, errdefs.NotFound(errorString("page not found: "+r.Method+" "+r.URL.Path)))
+	}
+}
+
+func (s *Server) exec(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "start":
+		var body types.ExecStartCheck
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, errdefs.InvalidParameter(err))
+			return
+		}
+
+		if !body.Detach {
+			s.execAttach(w, r, parts[0], body)
+			return
+		}
+
+		respond(w, http.StatusOK, nil, s.backend.ContainerExecStart(r.Context(), parts[0], body))
+
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "json":
+		info, err := s.backend.ContainerExecInspect(r.Context(), parts[0])
+		respond(w, http.StatusOK, info, err)
+
+	default:
+		writeError(w, errdefs.NotFound(errorString("page not found: "+r.Method+" "+r.URL.Path)))
+	}
+}
+
+// execAttach starts an exec and hijacks the connection like Docker does, copying the standard input of the command
+// from it and its output to it.
+func (s *Server) execAttach(w http.ResponseWriter, r *http.Request, execID string, body types.ExecStartCheck) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, errdefs.NotImplemented(errorString("connection can't be hijacked")))
+		return
+	}
+
+	// the connection outlives the request, so it mustn't be canceled along with it
+	resp, err := s.backend.ContainerExecAttach(context.Background(), execID, body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer resp.Close()
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = buffered.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\n" +
+		"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	_ = buffered.Flush()
+
+	go func() {
+		_, _ = io.Copy(resp.Conn, buffered)
+		_ = resp.CloseWrite()
+	}()
+
+	_, _ = io.Copy(conn, resp.Reader)
+}
+
+func (s *Server) imagePull(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ref := query.Get("fromImage")
+	if tag := query.Get("tag"); strings.HasPrefix(tag, "sha256:") {
+		ref += "@" + tag
+	} else if len(tag) > 0 {
+		ref += ":" + tag
+	}
+
+	options := types.ImagePullOptions{
+		RegistryAuth: r.Header.Get("X-Registry-Auth"),
+		Platform:     query.Get("platform"),
+	}
+
+	reader, err := s.backend.ImagePull(r.Context(), ref, options)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	copyFlushing(w, reader)
+}
+
+func (s *Server) imageBuild(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := types.ImageBuildOptions{
+		Tags:       query["t"],
+		Dockerfile: query.Get("dockerfile"),
+		Target:     query.Get("target"),
+		NoCache:    boolValue(query.Get("nocache")),
+		Remove:     boolValue(query.Get("rm")),
+	}
+
+	for param, value := range map[string]interface{}{"buildargs": &options.BuildArgs, "labels": &options.Labels} {
+		if encoded := query.Get(param); len(encoded) > 0 {
+			if err := json.Unmarshal([]byte(encoded), value); err != nil {
+				writeError(w, errdefs.InvalidParameter(err))
+				return
+			}
+		}
+	}
+
+	resp, err := s.backend.ImageBuild(r.Context(), r.Body, options)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	copyFlushing(w, resp.Body)
+}
+
+type errorString string
+
+func (e errorString) Error() string {
+	return string(e)
+}
+
+// boolValue mirrors how Docker interprets boolean query parameters.
+func boolValue(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return !(value == "" || value == "0" || value == "no" || value == "false" || value == "none")
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errdefs.GetHTTPErrorStatusCode(err), types.ErrorResponse{Message: err.Error()})
+}
+
+// copyFlushing copies a stream to the response, flushing after every write so clients see output immediately.
+func copyFlushing(w http.ResponseWriter, reader io.Reader) {
+	flusher, _ := w.(http.Flusher)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, werr := w.Write(buffer[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
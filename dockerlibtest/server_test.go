@@ -0,0 +1,442 @@
+package dockerlibtest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testImage = "python:3.9.11-alpine3.14"
+
+func TestServerEndToEnd(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.MountSourcePrefix = "/host_mnt"
+	engine.Script("dockerlib-test-server", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{
+			dockerlibtest.Stderr("INFO:root:Server started on port 8000").After(10 * time.Millisecond),
+		},
+	})
+	engine.Script("dockerlib-test-client", dockerlibtest.Script{
+		Lines:    []dockerlibtest.Line{dockerlibtest.Stdout("INFO:root:Status: 200")},
+		Exit:     true,
+		ExitCode: 0,
+	})
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+	t.Setenv("DOCKER_HOST", server.Host())
+
+	controller, err := dockerlib.NewDockerController()
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = controller.EnsureImage(ctx, testImage)
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	err = controller.EnsureNetwork(ctx, "dockerlib")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+	defer controller.CleanupNetworks(context.Background())
+
+	server1 := dockerlib.Container{
+		Name:    "dockerlib-test-server",
+		Image:   testImage,
+		Command: []string{"python", "/scripts/server.py", "/site"},
+		Mounts: []mount.Mount{
+			{Source: "/abs/testdata/hello.txt", Target: "/site/hello.txt", Type: mount.TypeBind, ReadOnly: true},
+		},
+		Network: []string{"dockerlib"},
+	}
+
+	ready, err := controller.Start(ctx, &server1, dockerlib.ForLog("Server started on port"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting server: %v", err)
+	}
+	defer controller.ShutdownAll(context.Background())
+
+	select {
+	case <-ready.Done():
+		if err := ready.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Test timeout - server didn't start.")
+	}
+
+	path, err := controller.GetContainerHostPath(ctx, "dockerlib-test-server", "/site/hello.txt")
+	if err != nil || path != filepath.FromSlash("/abs/testdata/hello.txt") {
+		t.Errorf("expected host path /abs/testdata/hello.txt, got %s (%v)", path, err)
+	}
+
+	client := dockerlib.Container{Name: "dockerlib-test-client", Image: testImage, Network: []string{"dockerlib"}}
+	_, err = controller.Start(ctx, &client, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting client: %v", err)
+	}
+
+	err = controller.WaitForShutdown(ctx, client, time.Second)
+	if err != nil {
+		t.Errorf("unexpected error when waiting for client: %v", err)
+	}
+
+	duplicate := dockerlib.Container{Name: "dockerlib-test-client", Image: testImage}
+	_, err = controller.Start(ctx, &duplicate, nil)
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("expected name conflict error, got %v", err)
+	}
+}
+
+func TestUnixServer(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+
+	server, err := dockerlibtest.NewUnixServer(engine, filepath.Join(t.TempDir(), "docker.sock"))
+	if err != nil {
+		t.Fatalf("unable to start server: %v", err)
+	}
+	defer server.Close()
+	t.Setenv("DOCKER_HOST", server.Host())
+
+	controller, err := dockerlib.NewDockerController()
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	err = controller.EnsureImage(context.Background(), "alpine")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	if !engine.HasImage("alpine") {
+		t.Errorf("expected image alpine to be pulled through the server")
+	}
+}
+
+func TestServerPullStreamError(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.FailPull("private/image", "pull access denied for private/image")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	err = controller.EnsureImage(context.Background(), "private/image")
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Fatalf("expected ImagePullError, got %v", err)
+	}
+}
+
+func TestServerPullIfNotPresent(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("library/alpine:3.15")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	err = controller.EnsureImage(context.Background(), "alpine:3.15", dockerlib.WithPullPolicy(dockerlib.PullIfNotPresent))
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	if engine.Calls("ImagePull") != 0 {
+		t.Errorf("expected present image not to be pulled")
+	}
+
+	err = controller.EnsureImage(context.Background(), "busybox", dockerlib.WithPullPolicy(dockerlib.PullNever))
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Errorf("expected ImagePullError, got %v", err)
+	}
+}
+
+func TestServerRegistryAuth(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.RequireAuth("registry.example.com", "user", "secret")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(
+		dockerlib.WithHost(server.Host()),
+		dockerlib.WithRegistryAuth("registry.example.com", types.AuthConfig{Username: "user", Password: "secret"}),
+	)
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	err = controller.EnsureImage(context.Background(), "registry.example.com/team/app")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	if !engine.HasImage("registry.example.com/team/app") {
+		t.Error("expected image to be pulled through the server")
+	}
+}
+
+func TestServerBuildImage(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	id, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files:     map[string][]byte{"Dockerfile": []byte("FROM alpine\nARG VERSION\nLABEL version=$VERSION\n")},
+		BuildArgs: map[string]string{"VERSION": "1.0"},
+		Labels:    map[string]string{"team": "platform"},
+		Tags:      []string{"example/app:1.0", "example/app:latest"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when building image: %v", err)
+	}
+
+	if !engine.HasImage(id) || !engine.HasImage("example/app:1.0") || !engine.HasImage("example/app") {
+		t.Errorf("expected image %s to be built with both tags", id)
+	}
+
+	options := engine.Builds()[0].Options
+	if *options.BuildArgs["VERSION"] != "1.0" || options.Labels["team"] != "platform" {
+		t.Errorf("expected build args and labels to be sent, got %+v", options)
+	}
+
+	_, err = controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files: map[string][]byte{"Dockerfile": []byte("FROM alpine\nRUN exit 2\n")},
+	})
+
+	var buildErr dockerlib.ImageBuildError
+	if !errors.As(err, &buildErr) || buildErr.Code != 2 {
+		t.Errorf("expected ImageBuildError with code 2, got %v", err)
+	}
+}
+
+func TestServerImageLifecycle(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+	ctx := context.Background()
+
+	err = controller.TagImage(ctx, "alpine", "registry.example.com/base/alpine:3")
+	if err != nil {
+		t.Fatalf("unexpected error when tagging image: %v", err)
+	}
+
+	info, err := controller.ImageInspect(ctx, "registry.example.com/base/alpine:3")
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting image: %v", err)
+	}
+
+	if len(info.Tags) != 2 || info.Size == 0 {
+		t.Errorf("unexpected image info: %+v", info)
+	}
+
+	err = controller.RemoveImage(ctx, "registry.example.com/base/alpine:3", dockerlib.RemoveImageOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error when removing image: %v", err)
+	}
+
+	_, err = controller.BuildImage(ctx, dockerlib.BuildSpec{
+		Files: map[string][]byte{"Dockerfile": []byte("FROM alpine\n")},
+		Tags:  []string{"example/app"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when building image: %v", err)
+	}
+
+	reclaimed, err := controller.PruneImages(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when pruning images: %v", err)
+	}
+
+	if reclaimed == 0 || engine.HasImage("example/app") || !engine.HasImage("alpine") {
+		t.Errorf("expected only the built image to be pruned, reclaimed %d", reclaimed)
+	}
+}
+
+func TestServerSaveAndLoad(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+	ctx := context.Background()
+
+	var tarball bytes.Buffer
+	err = controller.SaveImages(ctx, &tarball, "alpine")
+	if err != nil {
+		t.Fatalf("unexpected error when saving image: %v", err)
+	}
+
+	err = controller.RemoveImage(ctx, "alpine", dockerlib.RemoveImageOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error when removing image: %v", err)
+	}
+
+	loaded, err := controller.LoadImages(ctx, &tarball)
+	if err != nil {
+		t.Fatalf("unexpected error when loading image: %v", err)
+	}
+
+	if len(loaded) != 1 || loaded[0] != "alpine:latest" || !engine.HasImage("alpine") {
+		t.Errorf("expected alpine to be loaded, got %v", loaded)
+	}
+}
+
+func TestServerPlatform(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+	defer controller.ShutdownAll(context.Background())
+
+	container := dockerlib.Container{
+		Name:       "dockerlib-test-platform",
+		Image:      "alpine",
+		PullPolicy: dockerlib.PullIfNotPresent,
+		Platform:   &specs.Platform{OS: "linux", Architecture: "arm64"},
+	}
+	_, err = controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	info, err := controller.ImageInspect(context.Background(), "alpine")
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting image: %v", err)
+	}
+
+	if info.Architecture != "arm64" || engine.Calls("ImagePull") != 1 {
+		t.Errorf("expected alpine to be pulled for linux/arm64, got %s/%s", info.OS, info.Architecture)
+	}
+}
+
+func TestServerSessionCleanup(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+	ctx := context.Background()
+
+	err = controller.EnsureNetwork(ctx, "dockerlib-test")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	container := dockerlib.Container{
+		Name:    "dockerlib-test-session",
+		Image:   "alpine",
+		Network: []string{"dockerlib-test"},
+		Mounts:  []mount.Mount{{Type: mount.TypeVolume, Source: "dockerlib-test-data", Target: "/data"}},
+	}
+	_, err = controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	volumes, err := controller.ListVolumes(ctx)
+	if err != nil || len(volumes) != 1 || volumes[0].Labels[dockerlib.SessionLabel] != controller.SessionID() {
+		t.Errorf("expected the volume to be owned by the session, got %v (%v)", volumes, err)
+	}
+
+	for _, cleanup := range []func(context.Context) error{controller.ShutdownAll, controller.CleanupNetworks, controller.CleanupVolumes} {
+		err := cleanup(ctx)
+		if err != nil {
+			t.Errorf("unexpected error when cleaning up: %v", err)
+		}
+	}
+
+	if _, exists := engine.ContainerState(container.Name); exists || engine.NetworkExists("dockerlib-test") || engine.VolumeExists("dockerlib-test-data") {
+		t.Error("expected container, network and volume to be removed")
+	}
+}
+
+func TestServerExec(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage("alpine")
+	engine.OnExec(func(exec dockerlibtest.Exec) dockerlibtest.ExecResult {
+		return dockerlibtest.ExecResult{Stdout: strings.ToUpper(exec.Stdin), Stderr: "done\n", ExitCode: 1}
+	})
+
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(dockerlib.WithHost(server.Host()))
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+	defer controller.ShutdownAll(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{Name: "dockerlib-test-exec", Image: "alpine"}
+	_, err = controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	result, err := controller.Exec(ctx, container, dockerlib.ExecSpec{Cmd: []string{"tr", "a-z", "A-Z"}, Stdin: strings.NewReader("hello\n")})
+	if err != nil {
+		t.Fatalf("unexpected error when running command: %v", err)
+	}
+
+	want := dockerlib.ExecResult{Stdout: "HELLO\n", Stderr: "done\n", ExitCode: 1}
+	if result != want {
+		t.Errorf("expected %+v, got %+v", want, result)
+	}
+}
@@ -0,0 +1,165 @@
+package dockerlibtest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// manifestEntry is an entry of the manifest.json in a tarball created by `docker save`.
+type manifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// imageConfig is the configuration file of an image in a tarball created by `docker save`.
+type imageConfig struct {
+	Architecture string            `json:"architecture"`
+	OS           string            `json:"os"`
+	Variant      string            `json:"variant,omitempty"`
+	Created      time.Time         `json:"created"`
+	Config       *container.Config `json:"config"`
+}
+
+// ImageSave writes the given images to a tarball in the format used by `docker save`. Images referenced by tag are
+// saved with that tag, while images referenced by ID are saved without tags. Every image has a single layer of
+// the size of the image.
+func (e *Engine) ImageSave(ctx context.Context, imageIDs []string) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImageSave"); err != nil {
+		return nil, err
+	}
+
+	var order []*image
+	tags := make(map[*image][]string)
+	for _, ref := range imageIDs {
+		img := e.findImage(ref)
+		if img == nil {
+			return nil, NotFound("No such image: " + ref)
+		}
+
+		if _, seen := tags[img]; !seen {
+			order = append(order, img)
+			tags[img] = nil
+		}
+
+		if key := normalize(ref); img.matches(key) && ref != img.id && !strings.Contains(key, "@") {
+			tags[img] = append(tags[img], key)
+		}
+	}
+
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	add := func(name string, contents []byte) {
+		_ = writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})
+		_, _ = writer.Write(contents)
+	}
+
+	var manifest []manifestEntry
+	for _, img := range order {
+		hex := strings.TrimPrefix(img.id, "sha256:")
+		config, _ := json.Marshal(imageConfig{
+			Architecture: img.platform.Architecture,
+			OS:           img.platform.OS,
+			Variant:      img.platform.Variant,
+			Created:      img.created,
+			Config:       img.configCopy(),
+		})
+		add(hex+".json", config)
+		add(hex+"/layer.tar", make([]byte, img.size))
+
+		manifest = append(manifest, manifestEntry{Config: hex + ".json", RepoTags: tags[img], Layers: []string{hex + "/layer.tar"}})
+	}
+
+	encoded, _ := json.Marshal(manifest)
+	add("manifest.json", encoded)
+	_ = writer.Close()
+
+	return ioutil.NopCloser(&buffer), nil
+}
+
+// ImageLoad loads the images in a tarball created by `docker save` or ImageSave, which may be gzipped.
+func (e *Engine) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	files, err := readImageTarball(input)
+	if err != nil {
+		return types.ImageLoadResponse{}, errdefs.InvalidParameter(err)
+	}
+
+	var manifest []manifestEntry
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return types.ImageLoadResponse{}, errdefs.InvalidParameter(fmt.Errorf("invalid tarball: unable to read manifest.json: %v", err))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("ImageLoad"); err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, entry := range manifest {
+		var config imageConfig
+		if err := json.Unmarshal(files[entry.Config], &config); err != nil {
+			return types.ImageLoadResponse{}, errdefs.InvalidParameter(fmt.Errorf("invalid tarball: unable to read %s: %v", entry.Config, err))
+		}
+
+		id := "sha256:" + strings.TrimSuffix(entry.Config, ".json")
+		img, ok := e.images[id]
+		if !ok {
+			img = &image{id: id, config: config.Config, created: config.Created, platform: defaultPlatform}
+			if len(config.OS) > 0 {
+				img.platform = platforms.Normalize(specs.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant})
+			}
+			for _, layer := range entry.Layers {
+				img.size += int64(len(files[layer]))
+			}
+			e.images[id] = img
+		}
+
+		for _, tag := range entry.RepoTags {
+			if !img.matches(tag) {
+				e.tagImage(img, tag)
+			}
+			_ = encoder.Encode(map[string]string{"stream": "Loaded image: " + normalize(tag) + "\n"})
+		}
+
+		if len(entry.RepoTags) == 0 {
+			_ = encoder.Encode(map[string]string{"stream": "Loaded image ID: " + id + "\n"})
+		}
+	}
+
+	return types.ImageLoadResponse{Body: ioutil.NopCloser(&buffer), JSON: true}, nil
+}
+
+// readImageTarball reads the regular files in a tarball, decompressing it first if it is gzipped.
+func readImageTarball(input io.Reader) (map[string][]byte, error) {
+	reader := bufio.NewReader(input)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readBuildContext(gz)
+	}
+
+	return readBuildContext(reader)
+}
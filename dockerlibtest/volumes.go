@@ -0,0 +1,127 @@
+package dockerlibtest
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"time"
+)
+
+type fakeVolume struct {
+	name    string
+	labels  map[string]string
+	created time.Time
+}
+
+// VolumeExists returns whether a volume with the given name exists.
+func (e *Engine) VolumeExists(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.volumes[name]
+	return ok
+}
+
+// createVolumes creates the volumes of the volume mounts of a container that don't exist yet, labelled with the
+// labels of their VolumeOptions, and returns the names of the anonymous volumes that were created. It must be
+// called with the lock held.
+func (e *Engine) createVolumes(mounts []mount.Mount) []string {
+	var anonymous []string
+	for _, m := range mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+
+		name := m.Source
+		if len(name) == 0 {
+			name = e.newID("volume")
+			anonymous = append(anonymous, name)
+		}
+
+		if _, ok := e.volumes[name]; ok {
+			continue
+		}
+
+		var labels map[string]string
+		if m.VolumeOptions != nil {
+			labels = m.VolumeOptions.Labels
+		}
+		e.volumes[name] = &fakeVolume{name: name, labels: labels, created: time.Now()}
+	}
+
+	return anonymous
+}
+
+// volumeInUse returns whether a container uses the named volume. It must be called with the lock held.
+func (e *Engine) volumeInUse(name string) bool {
+	for _, c := range e.containers {
+		for _, anonymous := range c.anonymous {
+			if anonymous == name {
+				return true
+			}
+		}
+
+		for _, m := range c.hostConfig.Mounts {
+			if m.Type == mount.TypeVolume && m.Source == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// VolumeList lists the volumes matching the label filters.
+func (e *Engine) VolumeList(ctx context.Context, filter filters.Args) (volume.VolumeListOKBody, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("VolumeList"); err != nil {
+		return volume.VolumeListOKBody{}, err
+	}
+
+	result := volume.VolumeListOKBody{Volumes: []*types.Volume{}}
+	for _, v := range e.volumes {
+		if !matchesLabels(filter, v.labels) || (filter.Contains("name") && !filter.Match("name", v.name)) {
+			continue
+		}
+
+		result.Volumes = append(result.Volumes, &types.Volume{
+			Name:       v.name,
+			Driver:     "local",
+			Labels:     v.labels,
+			Mountpoint: "/var/lib/docker/volumes/" + v.name + "/_data",
+			Scope:      "local",
+			CreatedAt:  v.created.Format(time.RFC3339),
+		})
+	}
+
+	return result, nil
+}
+
+// VolumeRemove removes a volume, which must not be used by any container unless force is set.
+func (e *Engine) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.begin("VolumeRemove"); err != nil {
+		return err
+	}
+
+	if _, ok := e.volumes[volumeID]; !ok {
+		if force {
+			return nil
+		}
+		return NotFound("get " + volumeID + ": no such volume")
+	}
+
+	if e.volumeInUse(volumeID) && !force {
+		return Conflict("remove " + volumeID + ": volume is in use")
+	}
+
+	delete(e.volumes, volumeID)
+	e.notify()
+	return nil
+}
@@ -1,5 +1,10 @@
 package dockerlib
 
+import (
+	"fmt"
+	"strings"
+)
+
 type DockerError struct {
 	msg       string
 	baseError error
@@ -9,6 +14,10 @@ func (e DockerError) Error() string {
 	return e.msg + ": " + e.baseError.Error()
 }
 
+func (e DockerError) Unwrap() error {
+	return e.baseError
+}
+
 type ContainerError struct {
 	msg           string
 	containerName string
@@ -19,6 +28,10 @@ func (e ContainerError) Error() string {
 	return e.msg + " " + e.containerName + ": " + e.baseError.Error()
 }
 
+func (e ContainerError) Unwrap() error {
+	return e.baseError
+}
+
 type NetworkError struct {
 	msg         string
 	networkName string
@@ -28,3 +41,141 @@ type NetworkError struct {
 func (e NetworkError) Error() string {
 	return e.msg + " " + e.networkName + ": " + e.baseError.Error()
 }
+
+func (e NetworkError) Unwrap() error {
+	return e.baseError
+}
+
+type ImageError struct {
+	msg       string
+	imageName string
+	baseError error
+}
+
+func (e ImageError) Error() string {
+	return e.msg + " " + e.imageName + ": " + e.baseError.Error()
+}
+
+func (e ImageError) Unwrap() error {
+	return e.baseError
+}
+
+// ImagePullError indicates that Docker was unable to pull an image, either when the pull was requested or while
+// it was in progress. Code is the error code reported in the pull stream, if any.
+type ImagePullError struct {
+	Image     string
+	Message   string
+	Code      int
+	baseError error
+}
+
+func (e ImagePullError) Error() string {
+	return "unable to pull image " + e.Image + ": " + e.Message
+}
+
+func (e ImagePullError) Unwrap() error {
+	return e.baseError
+}
+
+// ImagePlatformError indicates that the local image is for a different platform (e.g. linux/amd64) than the one a
+// container requested (e.g. linux/arm64).
+type ImagePlatformError struct {
+	Image         string
+	Platform      string
+	ImagePlatform string
+}
+
+func (e ImagePlatformError) Error() string {
+	return "image " + e.Image + " is for platform " + e.ImagePlatform + ", not " + e.Platform
+}
+
+// ImageBuildError indicates that Docker was unable to build an image. Step is the step of the Dockerfile that
+// failed (e.g. "Step 2/3 : RUN make"), if the build got that far, and Output the last lines of build output.
+type ImageBuildError struct {
+	Step      string
+	Message   string
+	Code      int
+	Output    []LogLine
+	baseError error
+}
+
+func (e ImageBuildError) Error() string {
+	msg := "unable to build image"
+	if len(e.Step) > 0 {
+		msg += " at " + e.Step
+	}
+
+	return msg + ": " + e.Message + formatLogs(e.Output)
+}
+
+func (e ImageBuildError) Unwrap() error {
+	return e.baseError
+}
+
+// ContainerExitedError indicates that a container exited before it was ready.
+type ContainerExitedError struct {
+	Name     string
+	ExitCode int64
+	Logs     []LogLine
+}
+
+func (e ContainerExitedError) Error() string {
+	return fmt.Sprintf("container %s exited with code %d before it was ready%s", e.Name, e.ExitCode, formatLogs(e.Logs))
+}
+
+// LogsEndedError indicates that the output of a container ended before it was ready.
+type LogsEndedError struct {
+	Name string
+	Logs []LogLine
+}
+
+func (e LogsEndedError) Error() string {
+	return "logs of container " + e.Name + " ended before it was ready" + formatLogs(e.Logs)
+}
+
+func (e LogsEndedError) Unwrap() error {
+	return ErrLogsEnded
+}
+
+// ReadinessTimeoutError indicates that a container was not ready within the timeout of its WaitStrategy.
+type ReadinessTimeoutError struct {
+	Name      string
+	Logs      []LogLine
+	baseError error
+}
+
+func (e ReadinessTimeoutError) Error() string {
+	return "timed out waiting for container " + e.Name + " to be ready: " + e.baseError.Error() + formatLogs(e.Logs)
+}
+
+func (e ReadinessTimeoutError) Unwrap() error {
+	return e.baseError
+}
+
+// ReadinessError indicates that the WaitStrategy of a container failed for any other reason.
+type ReadinessError struct {
+	Name      string
+	Logs      []LogLine
+	baseError error
+}
+
+func (e ReadinessError) Error() string {
+	return "container " + e.Name + " did not become ready: " + e.baseError.Error() + formatLogs(e.Logs)
+}
+
+func (e ReadinessError) Unwrap() error {
+	return e.baseError
+}
+
+func formatLogs(logs []LogLine) string {
+	if len(logs) == 0 {
+		return ""
+	}
+
+	texts := make([]string, len(logs))
+	for i, line := range logs {
+		texts[i] = line.Text
+	}
+
+	return "; last logs:\n" + strings.Join(texts, "\n")
+}
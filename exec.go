@@ -0,0 +1,208 @@
+package dockerlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"time"
+)
+
+// ExecSpec describes a command to run inside a running container.
+type ExecSpec struct {
+	// Cmd is the command to run, e.g. []string{"psql", "-c", "SELECT 1"}.
+	Cmd []string
+	// Env are additional environment variables of the command, e.g. "PGPASSWORD=secret".
+	Env []string
+	// WorkingDir is the directory the command runs in, instead of the working directory of the container.
+	WorkingDir string
+	// User is the user (and optionally group, e.g. "postgres:postgres") the command runs as, instead of the user
+	// of the container.
+	User string
+	// Stdin, if set, is copied to the standard input of the command, which is closed once Stdin is exhausted. Once
+	// the command has exited, Stdin is no longer read, but a Read in progress is waited for, so it shouldn't block
+	// indefinitely. If the context is done first, the Read isn't waited for, and what it returns is discarded.
+	Stdin io.Reader
+	// Tty runs the command with a pseudo-TTY, in which case all of its output is reported as standard output.
+	Tty bool
+}
+
+// validate returns an error if the command can't be run.
+func (s ExecSpec) validate() error {
+	if len(s.Cmd) == 0 {
+		return errors.New("exec requires a command")
+	}
+
+	return nil
+}
+
+// ExecResult is the outcome of a command run by DockerController.Exec.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Exec runs a command inside the specified running Container and waits for it to exit, returning its output and
+// exit code. A command that exits with a non-zero code isn't an error.
+func (controller *DockerController) Exec(ctx context.Context, c Container, spec ExecSpec) (ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := controller.exec(ctx, c, spec, &stdout, &stderr)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: exitCode}, nil
+}
+
+// ExecStream runs a command inside the specified running Container like Exec, but delivers its output to consumer
+// line by line as it is written, from a single goroutine, instead of returning it. It returns the exit code of the
+// command once it has exited.
+func (controller *DockerController) ExecStream(ctx context.Context, c Container, spec ExecSpec, consumer LogConsumer) (int, error) {
+	stdout := &lineWriter{stream: Stdout, consumer: consumer}
+	stderr := &lineWriter{stream: Stderr, consumer: consumer}
+
+	exitCode, err := controller.exec(ctx, c, spec, stdout, stderr)
+	stdout.flush()
+	stderr.flush()
+
+	return exitCode, err
+}
+
+// exec runs a command inside a container, copying its output to stdout and stderr, and returns its exit code.
+func (controller *DockerController) exec(ctx context.Context, c Container, spec ExecSpec, stdout, stderr io.Writer) (int, error) {
+	err := spec.validate()
+	if err != nil {
+		return 0, ContainerError{"invalid exec for container", c.Name, err}
+	}
+
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	config := types.ExecConfig{
+		User:         spec.User,
+		Tty:          spec.Tty,
+		AttachStdin:  spec.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          spec.Env,
+		WorkingDir:   spec.WorkingDir,
+		Cmd:          spec.Cmd,
+	}
+
+	controller.log().Infof("Running %v in container %s", spec.Cmd, c.Name)
+	created, err := controller.cli.ContainerExecCreate(ctx, c.ID, config)
+	if err != nil {
+		controller.log().Errorf("Unable to create exec in container %s: %v", c.Name, err)
+		return 0, ContainerError{"unable to create exec in container", c.Name, err}
+	}
+
+	resp, err := controller.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: spec.Tty})
+	if err != nil {
+		controller.log().Errorf("Unable to attach to exec in container %s: %v", c.Name, err)
+		return 0, ContainerError{"unable to attach to exec in container", c.Name, err}
+	}
+	defer resp.Close()
+
+	// the command may exit without reading all of its input, so the connection is closed once its output has been
+	// read, which stops the copy, and the copy is waited for so Stdin isn't read after returning, unless ctx is done
+	// since closing the connection doesn't interrupt a Read of Stdin that blocks
+	stdinCopied := make(chan struct{})
+	if spec.Stdin != nil {
+		go func() {
+			defer close(stdinCopied)
+			_, _ = io.Copy(resp.Conn, spec.Stdin)
+			_ = resp.CloseWrite()
+		}()
+	} else {
+		close(stdinCopied)
+	}
+
+	copied := make(chan error, 1)
+	go func() {
+		var err error
+		if spec.Tty {
+			_, err = io.Copy(stdout, resp.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdout, stderr, resp.Reader)
+		}
+		copied <- err
+	}()
+
+	select {
+	case err = <-copied:
+		resp.Close()
+	case <-ctx.Done():
+		// closing the connection stops the copies, but not the command
+		resp.Close()
+		<-copied
+		err = ctx.Err()
+	}
+	select {
+	case <-stdinCopied:
+	case <-ctx.Done():
+	}
+	if err != nil {
+		controller.log().Errorf("Unable to read output of exec in container %s: %v", c.Name, err)
+		return 0, ContainerError{"unable to read output of exec in container", c.Name, err}
+	}
+
+	return controller.execWait(ctx, c, created.ID)
+}
+
+// execWait waits for an exec to exit and returns its exit code.
+func (controller *DockerController) execWait(ctx context.Context, c Container, execID string) (int, error) {
+	ticker := time.NewTicker(pollInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		info, err := controller.cli.ContainerExecInspect(ctx, execID)
+		if err != nil {
+			return 0, ContainerError{"unable to inspect exec in container", c.Name, err}
+		}
+
+		if !info.Running {
+			return info.ExitCode, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// lineWriter splits the output written to it into lines, which it passes to consumer.
+type lineWriter struct {
+	stream   LogStream
+	consumer LogConsumer
+	partial  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		w.accept(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// flush passes the last line to consumer if it didn't end with a newline.
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.accept(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) accept(line []byte) {
+	w.consumer.Accept(LogLine{Stream: w.stream, Text: string(bytes.TrimSuffix(line, []byte("\r")))})
+}
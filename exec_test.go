@@ -0,0 +1,243 @@
+package dockerlib_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	var got dockerlibtest.Exec
+	engine.OnExec(func(exec dockerlibtest.Exec) dockerlibtest.ExecResult {
+		got = exec
+		return dockerlibtest.ExecResult{Stdout: "1 row\n", Stderr: "warning\n", ExitCode: 3}
+	})
+
+	c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(ctx, &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	spec := dockerlib.ExecSpec{
+		Cmd:        []string{"psql", "-f", "-"},
+		Env:        []string{"PGPASSWORD=secret"},
+		WorkingDir: "/tmp",
+		User:       "postgres",
+		Stdin:      strings.NewReader("SELECT 1;"),
+	}
+	result, err := controller.Exec(ctx, c, spec)
+	if err != nil {
+		t.Fatalf("unexpected error when running command: %v", err)
+	}
+
+	want := dockerlib.ExecResult{Stdout: "1 row\n", Stderr: "warning\n", ExitCode: 3}
+	if result != want {
+		t.Errorf("expected %+v, got %+v", want, result)
+	}
+
+	wantExec := dockerlibtest.Exec{
+		Container: "dockerlib-test",
+		Cmd:       spec.Cmd,
+		Env:       spec.Env,
+		User:      "postgres",
+		Dir:       "/tmp",
+		Stdin:     "SELECT 1;",
+	}
+	if !reflect.DeepEqual(got, wantExec) {
+		t.Errorf("expected %+v to be run, got %+v", wantExec, got)
+	}
+
+	// with a TTY, all output is reported as standard output
+	result, err = controller.Exec(ctx, c, dockerlib.ExecSpec{Cmd: []string{"psql"}, Tty: true})
+	if err != nil {
+		t.Fatalf("unexpected error when running command with TTY: %v", err)
+	}
+	if result.Stdout != "1 row\nwarning\n" || result.Stderr != "" || !got.Tty || len(got.Stdin) != 0 {
+		t.Errorf("unexpected result with TTY %+v for %+v", result, got)
+	}
+}
+
+func TestExecStream(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	engine.OnExec(func(exec dockerlibtest.Exec) dockerlibtest.ExecResult {
+		return dockerlibtest.ExecResult{Stdout: "first\r\nsecond\nlast", Stderr: "oops\n"}
+	})
+
+	c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(ctx, &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	var lines []dockerlib.LogLine
+	exitCode, err := controller.ExecStream(ctx, c, dockerlib.ExecSpec{Cmd: []string{"migrate"}}, dockerlib.LogFunc(func(line dockerlib.LogLine) {
+		lines = append(lines, line)
+	}))
+	if err != nil || exitCode != 0 {
+		t.Fatalf("unexpected result when streaming command: %d %v", exitCode, err)
+	}
+
+	want := append(stdout("first", "second"), dockerlib.LogLine{Stream: dockerlib.Stderr, Text: "oops"}, dockerlib.LogLine{Stream: dockerlib.Stdout, Text: "last"})
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected lines %v, got %v", want, lines)
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(ctx, &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	_, err = controller.Exec(ctx, c, dockerlib.ExecSpec{})
+	if err == nil || !strings.Contains(err.Error(), "invalid exec for container dockerlib-test") {
+		t.Errorf("expected error without command, got %v", err)
+	}
+
+	_ = engine.Exit(c.Name, 0)
+	_, err = controller.Exec(ctx, c, dockerlib.ExecSpec{Cmd: []string{"true"}})
+	if err == nil || !strings.Contains(err.Error(), "unable to create exec in container dockerlib-test") {
+		t.Errorf("expected error when container isn't running, got %v", err)
+	}
+}
+
+// endlessReader is standard input that is never exhausted, recording reads that complete once returned is set.
+type endlessReader struct {
+	returned  int32
+	lateReads int32
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	if atomic.LoadInt32(&r.returned) == 1 {
+		atomic.AddInt32(&r.lateReads, 1)
+	}
+	return copy(p, "y\n"), nil
+}
+
+// exitedConn is the connection to a command that exited without reading its input: its output is available right
+// away, and writes are discarded until it is closed.
+type exitedConn struct {
+	net.Conn
+	output io.Reader
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *exitedConn) Read(p []byte) (int, error) {
+	return c.output.Read(p)
+}
+
+func (c *exitedConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+		return len(p), nil
+	}
+}
+
+func (c *exitedConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// exitedExecEngine runs commands without reading their input.
+type exitedExecEngine struct {
+	*dockerlibtest.Engine
+}
+
+func (e exitedExecEngine) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	err := e.ContainerExecStart(ctx, execID, config)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+
+	var output bytes.Buffer
+	_, _ = stdcopy.NewStdWriter(&output, stdcopy.Stdout).Write([]byte("exited\n"))
+	conn := &exitedConn{output: &output, closed: make(chan struct{})}
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+func TestExecStdinOpenAfterExit(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	controller := dockerlib.NewDockerControllerFromClient(exitedExecEngine{engine})
+	defer controller.ShutdownAll(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(ctx, &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	stdin := &endlessReader{}
+	result, err := controller.Exec(ctx, c, dockerlib.ExecSpec{Cmd: []string{"true"}, Stdin: stdin})
+	atomic.StoreInt32(&stdin.returned, 1)
+	if err != nil || result.Stdout != "exited\n" {
+		t.Fatalf("unexpected result when running command: %+v %v", result, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&stdin.lateReads); n > 0 {
+		t.Errorf("expected stdin not to be read after Exec returned, got %d reads", n)
+	}
+}
+
+func TestExecStdinBlockedCancelled(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	c := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(context.Background(), &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	// nothing is ever written, so reading stdin blocks until the pipe is closed
+	stdin, pw := io.Pipe()
+	defer pw.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := controller.Exec(ctx, c, dockerlib.ExecSpec{Cmd: []string{"cat"}, Stdin: stdin})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error of the context, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Exec to return once its context is done")
+	}
+}
@@ -0,0 +1,139 @@
+package dockerlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"time"
+)
+
+// Health statuses reported by Docker for a container, as well as HealthNone for a container without a health check.
+const (
+	HealthNone      = types.NoHealthcheck
+	HealthStarting  = types.Starting
+	HealthHealthy   = types.Healthy
+	HealthUnhealthy = types.Unhealthy
+)
+
+// minHealthCheckDuration is the shortest interval, timeout or start period Docker accepts for a health check.
+const minHealthCheckDuration = time.Millisecond
+
+// HealthCheck configures how Docker checks that a container is healthy, overriding the HEALTHCHECK of its image.
+// Zero values are inherited from the image, or use Docker's defaults.
+type HealthCheck struct {
+	// Test is the command that checks the container, e.g. []string{"CMD", "pg_isready"} or
+	// []string{"CMD-SHELL", "curl -f http://localhost/"}. A command without a CMD or CMD-SHELL prefix is run as if
+	// it had the CMD prefix, and []string{"NONE"} disables the health check of the image.
+	Test []string
+	// Interval is the time between checks.
+	Interval time.Duration
+	// Timeout is how long a check can take before it is considered to have failed.
+	Timeout time.Duration
+	// StartPeriod is how long the container has to start before failed checks count towards Retries.
+	StartPeriod time.Duration
+	// Retries is the number of consecutive failed checks before the container is considered unhealthy.
+	Retries int
+}
+
+// validate returns an error if Docker would reject the health check.
+func (h HealthCheck) validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"interval", h.Interval},
+		{"timeout", h.Timeout},
+		{"start period", h.StartPeriod},
+	}
+	for _, d := range durations {
+		if d.value != 0 && d.value < minHealthCheckDuration {
+			return fmt.Errorf("health check %s must be at least %v, got %v", d.name, minHealthCheckDuration, d.value)
+		}
+	}
+
+	if h.Retries < 0 {
+		return fmt.Errorf("health check retries must not be negative, got %d", h.Retries)
+	}
+
+	if len(h.Test) == 1 && (h.Test[0] == "CMD" || h.Test[0] == "CMD-SHELL") {
+		return errors.New("health check " + h.Test[0] + " requires a command")
+	}
+
+	return nil
+}
+
+// config returns the health check in the form used by the Docker API.
+func (h HealthCheck) config() *container.HealthConfig {
+	test := h.Test
+	if len(test) > 0 {
+		switch test[0] {
+		case "NONE", "CMD", "CMD-SHELL":
+		default:
+			test = append([]string{"CMD"}, test...)
+		}
+	}
+
+	return &container.HealthConfig{
+		Test:        test,
+		Interval:    h.Interval,
+		Timeout:     h.Timeout,
+		StartPeriod: h.StartPeriod,
+		Retries:     h.Retries,
+	}
+}
+
+// HealthCheckResult is the outcome of a single health check.
+type HealthCheckResult struct {
+	Start    time.Time
+	End      time.Time
+	ExitCode int
+	Output   string
+}
+
+// ContainerHealth is the health of a container as reported by Docker.
+type ContainerHealth struct {
+	// Status is one of HealthStarting, HealthHealthy or HealthUnhealthy, or HealthNone if the container doesn't
+	// have a health check.
+	Status string
+	// FailingStreak is the number of consecutive failed checks.
+	FailingStreak int
+	// Log holds the most recent checks, oldest first. Docker retains the last 5.
+	Log []HealthCheckResult
+}
+
+// Health returns the current health status of the specified Container, along with the most recent health checks.
+func (controller *DockerController) Health(ctx context.Context, c Container) (ContainerHealth, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	info, err := controller.cli.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		controller.log().Errorf("Unable to inspect container %s: %v", c, err)
+		return ContainerHealth{}, ContainerError{"unable to inspect container", c.Name, err}
+	}
+
+	if info.ContainerJSONBase == nil || info.State == nil || info.State.Health == nil {
+		return ContainerHealth{Status: HealthNone}, nil
+	}
+
+	health := ContainerHealth{
+		Status:        info.State.Health.Status,
+		FailingStreak: info.State.Health.FailingStreak,
+	}
+	for _, result := range info.State.Health.Log {
+		if result == nil {
+			continue
+		}
+
+		health.Log = append(health.Log, HealthCheckResult{
+			Start:    result.Start,
+			End:      result.End,
+			ExitCode: result.ExitCode,
+			Output:   result.Output,
+		})
+	}
+
+	return health, nil
+}
@@ -0,0 +1,141 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	container := dockerlib.Container{
+		Name:  "dockerlib-test-health",
+		Image: TestImage,
+		HealthCheck: &dockerlib.HealthCheck{
+			Test:     []string{"pg_isready", "-U", "postgres"},
+			Interval: 5 * time.Second,
+			Retries:  2,
+		},
+	}
+	_, err := controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	info, err := engine.ContainerInspect(ctx, container.ID)
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting container: %v", err)
+	}
+
+	check := info.Config.Healthcheck
+	if check == nil || !cmp.Equal(check.Test, []string{"CMD", "pg_isready", "-U", "postgres"}) || check.Interval != 5*time.Second || check.Retries != 2 {
+		t.Errorf("unexpected health check configuration: %+v", check)
+	}
+
+	steps := []struct {
+		exitCode int
+		output   string
+		status   string
+		streak   int
+	}{
+		{1, "no response", dockerlib.HealthStarting, 1},
+		{1, "no response", dockerlib.HealthUnhealthy, 2},
+		{0, "accepting connections", dockerlib.HealthHealthy, 0},
+	}
+
+	for _, step := range steps {
+		_ = engine.RecordHealthCheck(container.Name, step.exitCode, step.output)
+
+		health, err := controller.Health(ctx, container)
+		if err != nil {
+			t.Fatalf("unexpected error when getting health: %v", err)
+		}
+
+		if health.Status != step.status || health.FailingStreak != step.streak {
+			t.Errorf("expected status %s with failing streak %d, got %s with %d", step.status, step.streak, health.Status, health.FailingStreak)
+		}
+	}
+
+	health, _ := controller.Health(ctx, container)
+	if len(health.Log) != 3 || health.Log[2].ExitCode != 0 || health.Log[2].Output != "accepting connections" {
+		t.Errorf("unexpected health check log: %+v", health.Log)
+	}
+}
+
+func TestHealthWithoutHealthCheck(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	container := dockerlib.Container{Name: "dockerlib-test-health", Image: TestImage}
+	_, err := controller.Start(context.Background(), &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	health, err := controller.Health(context.Background(), container)
+	if err != nil {
+		t.Fatalf("unexpected error when getting health: %v", err)
+	}
+
+	if health.Status != dockerlib.HealthNone {
+		t.Errorf("expected status %s, got %s", dockerlib.HealthNone, health.Status)
+	}
+}
+
+func TestHealthCheckReadiness(t *testing.T) {
+	controller, engine := newFakeController(t)
+
+	container := dockerlib.Container{
+		Name:        "dockerlib-test-health",
+		Image:       TestImage,
+		HealthCheck: &dockerlib.HealthCheck{Test: []string{"CMD-SHELL", "curl -f http://localhost/"}},
+	}
+	ready, err := controller.Start(context.Background(), &container, dockerlib.ForHealthy())
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	_ = engine.RecordHealthCheck(container.Name, 0, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = ready.Wait(ctx)
+	if err != nil {
+		t.Errorf("expected container to become ready once healthy, got %v", err)
+	}
+}
+
+func TestHealthCheckInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		check dockerlib.HealthCheck
+	}{
+		{"interval too short", dockerlib.HealthCheck{Test: []string{"true"}, Interval: time.Microsecond}},
+		{"negative timeout", dockerlib.HealthCheck{Test: []string{"true"}, Timeout: -time.Second}},
+		{"negative retries", dockerlib.HealthCheck{Test: []string{"true"}, Retries: -1}},
+		{"missing command", dockerlib.HealthCheck{Test: []string{"CMD-SHELL"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller, engine := newFakeController(t)
+
+			container := dockerlib.Container{Name: "dockerlib-test-health", Image: TestImage, HealthCheck: &test.check}
+			_, err := controller.Start(context.Background(), &container, nil)
+
+			var containerErr dockerlib.ContainerError
+			if !errors.As(err, &containerErr) {
+				t.Errorf("expected ContainerError, got %v", err)
+			}
+
+			if engine.Calls("ContainerCreate") != 0 {
+				t.Error("expected container not to be created with an invalid health check")
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package dockerlib
+
+import (
+	"context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"sort"
+	"time"
+)
+
+// ManagedLabel is the label the controller adds to the images it builds, which PruneImages removes by default, and
+// to every other resource it creates.
+const ManagedLabel = "dockerlib.managed"
+
+// ImageInfo describes a local image, as returned by DockerController.ImageInspect.
+type ImageInfo struct {
+	ID          string
+	Tags        []string
+	RepoDigests []string
+	// Digest is the digest of the image in the registry it was pulled from, and is empty for images that were
+	// built locally.
+	Digest       string
+	Size         int64
+	Created      time.Time
+	Labels       map[string]string
+	ExposedPorts []string
+	Entrypoint   []string
+	Cmd          []string
+	Env          []string
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// RemoveImageOptions configures how DockerController.RemoveImage removes an image.
+type RemoveImageOptions struct {
+	// Force removes the image even if it is tagged in multiple repositories or used by a stopped container.
+	Force bool
+	// NoPrune keeps untagged parent images.
+	NoPrune bool
+}
+
+// ImageInspect returns information about the local image with the given reference or ID.
+func (controller *DockerController) ImageInspect(ctx context.Context, image string) (ImageInfo, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	inspect, _, err := controller.cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		controller.log().Errorf("Unable to inspect image %s: %v", image, err)
+		return ImageInfo{}, ImageError{"unable to inspect image", image, err}
+	}
+
+	info := ImageInfo{
+		ID:           inspect.ID,
+		Tags:         inspect.RepoTags,
+		RepoDigests:  inspect.RepoDigests,
+		Size:         inspect.Size,
+		OS:           inspect.Os,
+		Architecture: inspect.Architecture,
+		Variant:      inspect.Variant,
+	}
+
+	if created, err := time.Parse(time.RFC3339Nano, inspect.Created); err == nil {
+		info.Created = created
+	}
+
+	for _, repoDigest := range inspect.RepoDigests {
+		named, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if canonical, ok := named.(reference.Canonical); ok {
+			info.Digest = canonical.Digest().String()
+			break
+		}
+	}
+
+	if config := inspect.Config; config != nil {
+		info.Labels = config.Labels
+		info.Entrypoint = config.Entrypoint
+		info.Cmd = config.Cmd
+		info.Env = config.Env
+		for port := range config.ExposedPorts {
+			info.ExposedPorts = append(info.ExposedPorts, string(port))
+		}
+		sort.Strings(info.ExposedPorts)
+	}
+
+	return info, nil
+}
+
+// TagImage adds the target reference (e.g. registry.example.com/app:1.0) to the local source image.
+func (controller *DockerController) TagImage(ctx context.Context, source string, target string) error {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	controller.log().Infof("Tagging image %s as %s", source, target)
+	err := controller.cli.ImageTag(ctx, source, target)
+	if err != nil {
+		controller.log().Errorf("Unable to tag image %s as %s: %v", source, target, err)
+		return ImageError{"unable to tag image as " + target, source, err}
+	}
+
+	return nil
+}
+
+// RemoveImage removes the local image with the given reference or ID. If the image has other tags, only the given
+// reference is removed unless Force is set.
+func (controller *DockerController) RemoveImage(ctx context.Context, image string, options RemoveImageOptions) error {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	controller.log().Infof("Trying to remove image %s...", image)
+	_, err := controller.cli.ImageRemove(ctx, image, types.ImageRemoveOptions{Force: options.Force, PruneChildren: !options.NoPrune})
+	if err != nil {
+		controller.log().Errorf("Unable to remove image %s: %v", image, err)
+		return ImageError{"unable to remove image", image, err}
+	}
+
+	return nil
+}
+
+// PruneImages removes the images that aren't used by any container and match all of the given filters (e.g.
+// filters.Arg("label", "team=platform")), returning the number of bytes reclaimed. Without filters, the images
+// built by the controller (labelled with ManagedLabel) are removed. Tagged images are removed too, unless the
+// filters include "dangling".
+func (controller *DockerController) PruneImages(ctx context.Context, args ...filters.KeyValuePair) (uint64, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	if len(args) == 0 {
+		args = []filters.KeyValuePair{filters.Arg("label", ManagedLabel)}
+	}
+
+	pruneFilters := filters.NewArgs(args...)
+	if !pruneFilters.Contains("dangling") {
+		pruneFilters.Add("dangling", "false")
+	}
+
+	report, err := controller.cli.ImagesPrune(ctx, pruneFilters)
+	if err != nil {
+		controller.log().Errorf("Unable to prune images: %v", err)
+		return 0, DockerError{"unable to prune images", err}
+	}
+
+	deleted := 0
+	for _, item := range report.ImagesDeleted {
+		if len(item.Deleted) > 0 {
+			deleted++
+		}
+	}
+
+	controller.log().Infof("Pruned %d images, reclaiming %d bytes", deleted, report.SpaceReclaimed)
+	return report.SpaceReclaimed, nil
+}
@@ -0,0 +1,178 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/docker/api/types/filters"
+	"strings"
+	"testing"
+)
+
+func buildTestImage(t *testing.T, controller *dockerlib.DockerController, tag string, labels map[string]string) string {
+	id, err := controller.BuildImage(context.Background(), dockerlib.BuildSpec{
+		Files: map[string][]byte{
+			"Dockerfile": []byte("FROM alpine\nENV MODE=test\nEXPOSE 8080 9090/udp\nCMD [\"serve\"]\n"),
+		},
+		Labels: labels,
+		Tags:   []string{tag},
+	})
+	if err != nil {
+		t.Fatalf("unable to build image %s: %v", tag, err)
+	}
+
+	return id
+}
+
+func TestImageInspect(t *testing.T) {
+	controller, _ := newFakeController(t)
+	id := buildTestImage(t, controller, "example/app:1.0", map[string]string{"team": "platform"})
+
+	info, err := controller.ImageInspect(context.Background(), "example/app:1.0")
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting image: %v", err)
+	}
+
+	if info.ID != id || info.Size <= 0 || info.Created.IsZero() {
+		t.Errorf("unexpected image info: %+v", info)
+	}
+
+	if info.Labels["team"] != "platform" || info.Labels[dockerlib.ManagedLabel] != "true" {
+		t.Errorf("unexpected labels: %v", info.Labels)
+	}
+
+	if strings.Join(info.ExposedPorts, ",") != "8080/tcp,9090/udp" {
+		t.Errorf("unexpected exposed ports: %v", info.ExposedPorts)
+	}
+
+	if strings.Join(info.Cmd, " ") != "serve" || strings.Join(info.Env, ",") != "MODE=test" {
+		t.Errorf("unexpected config cmd=%v env=%v", info.Cmd, info.Env)
+	}
+
+	if info.Digest != "" {
+		t.Errorf("expected locally built image not to have a digest, got %s", info.Digest)
+	}
+
+	pulled, err := controller.ImageInspect(context.Background(), TestImage)
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting image: %v", err)
+	}
+
+	if !strings.HasPrefix(pulled.Digest, "sha256:") {
+		t.Errorf("expected pulled image to have a digest, got %q", pulled.Digest)
+	}
+
+	_, err = controller.ImageInspect(context.Background(), "missing")
+
+	var imageErr dockerlib.ImageError
+	if !errors.As(err, &imageErr) {
+		t.Errorf("expected ImageError, got %v", err)
+	}
+}
+
+func TestTagAndRemoveImage(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+	id := buildTestImage(t, controller, "example/app:1.0", nil)
+
+	err := controller.TagImage(ctx, "example/app:1.0", "registry.example.com/app:1.0")
+	if err != nil {
+		t.Fatalf("unexpected error when tagging image: %v", err)
+	}
+
+	info, _ := controller.ImageInspect(ctx, id)
+	if len(info.Tags) != 2 {
+		t.Errorf("expected image to have 2 tags, got %v", info.Tags)
+	}
+
+	err = controller.RemoveImage(ctx, id, dockerlib.RemoveImageOptions{})
+	if err == nil {
+		t.Error("expected error when removing image with multiple tags by ID")
+	}
+
+	err = controller.RemoveImage(ctx, "registry.example.com/app:1.0", dockerlib.RemoveImageOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error when removing tag: %v", err)
+	}
+
+	if engine.HasImage("registry.example.com/app:1.0") || !engine.HasImage(id) {
+		t.Error("expected only the tag to be removed")
+	}
+
+	err = controller.TagImage(ctx, id, "example/app:latest")
+	if err != nil {
+		t.Fatalf("unexpected error when tagging image: %v", err)
+	}
+
+	err = controller.RemoveImage(ctx, id, dockerlib.RemoveImageOptions{Force: true})
+	if err != nil {
+		t.Fatalf("unexpected error when force removing image: %v", err)
+	}
+
+	if engine.HasImage(id) || engine.HasImage("example/app:latest") {
+		t.Error("expected image to be removed")
+	}
+}
+
+func TestRemoveImageInUse(t *testing.T) {
+	controller, _ := newFakeController(t)
+	ctx := context.Background()
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	_, err := controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = controller.RemoveImage(ctx, TestImage, dockerlib.RemoveImageOptions{Force: true})
+	if err == nil {
+		t.Error("expected error when removing image used by a running container")
+	}
+}
+
+func TestPruneImages(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	first := buildTestImage(t, controller, "example/first", map[string]string{"team": "platform"})
+	second := buildTestImage(t, controller, "example/second", nil)
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: "example/second"}
+	_, err := controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	firstInfo, _ := controller.ImageInspect(ctx, first)
+
+	reclaimed, err := controller.PruneImages(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when pruning images: %v", err)
+	}
+
+	if reclaimed != uint64(firstInfo.Size) {
+		t.Errorf("expected %d bytes to be reclaimed, got %d", firstInfo.Size, reclaimed)
+	}
+
+	if engine.HasImage(first) {
+		t.Error("expected unused managed image to be pruned")
+	}
+
+	if !engine.HasImage(second) {
+		t.Error("expected image used by a container not to be pruned")
+	}
+
+	if !engine.HasImage(TestImage) {
+		t.Error("expected image not built by the controller not to be pruned")
+	}
+
+	engine.AddImage("example/other")
+	reclaimed, err = controller.PruneImages(ctx, filters.Arg("label!", dockerlib.ManagedLabel))
+	if err != nil {
+		t.Fatalf("unexpected error when pruning images: %v", err)
+	}
+
+	if reclaimed == 0 || engine.HasImage("example/other") {
+		t.Errorf("expected unmanaged image to be pruned with filters, reclaimed %d", reclaimed)
+	}
+}
@@ -1,7 +1,9 @@
 package dockerlib
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"io"
 )
 
@@ -52,3 +54,115 @@ func ReadLinesAsBytes(reader io.Reader) <-chan []byte {
 
 	return lines
 }
+
+// LogStream identifies the stream a line of container output was written to.
+type LogStream int
+
+const (
+	// Stdout is the standard output of a container.
+	Stdout LogStream = iota + 1
+	// Stderr is the standard error of a container.
+	Stderr
+)
+
+func (s LogStream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	default:
+		return "unknown"
+	}
+}
+
+// LogLine is a single line of container output along with the stream it was written to.
+type LogLine struct {
+	Stream LogStream
+	Text   string
+}
+
+func (l LogLine) String() string {
+	return l.Text
+}
+
+// maxLogFrameSize is the largest payload ReadLogLines accepts in a frame, well above what Docker writes at once, so
+// that output that isn't multiplexed (e.g. of a container with a TTY) isn't mistaken for a huge frame.
+const maxLogFrameSize = 16 << 20
+
+// ReadLogLines demultiplexes the stream Docker returns for the logs (or attached output) of a container without a
+// TTY, where every frame starts with an 8 byte header identifying the stream and the size of the payload. Lines
+// are sent in the order they were written, and the channel is closed when the reader is exhausted or when a header
+// is invalid, meaning the output isn't multiplexed.
+func ReadLogLines(reader io.Reader) <-chan LogLine {
+	lines := make(chan LogLine)
+	go func() {
+		defer close(lines)
+
+		header := make([]byte, 8)
+		partial := make(map[LogStream][]byte, 2)
+		sep := []byte("\n")
+		for {
+			_, err := io.ReadFull(reader, header)
+			if err != nil {
+				if err != io.EOF {
+					logger.Errorf("Unexpected error reading log header: %v", err)
+				}
+				break
+			}
+
+			size := binary.BigEndian.Uint32(header[4:])
+			if header[0] > 3 || header[1] != 0 || header[2] != 0 || header[3] != 0 || size > maxLogFrameSize {
+				logger.Errorf("Invalid log header %q, output isn't multiplexed", header)
+				break
+			}
+
+			stream := Stdout
+			if header[0] == 2 || header[0] == 3 {
+				stream = Stderr
+			}
+
+			payload := make([]byte, size)
+			_, err = io.ReadFull(reader, payload)
+			if err != nil {
+				logger.Errorf("Unexpected error reading log payload: %v", err)
+				break
+			}
+
+			parts := bytes.Split(append(partial[stream], payload...), sep)
+			for _, part := range parts[:len(parts)-1] {
+				lines <- LogLine{Stream: stream, Text: string(bytes.Trim(part, "\r"))}
+			}
+			partial[stream] = parts[len(parts)-1]
+		}
+
+		for _, stream := range []LogStream{Stdout, Stderr} {
+			if len(partial[stream]) > 0 {
+				lines <- LogLine{Stream: stream, Text: string(bytes.Trim(partial[stream], "\r"))}
+			}
+		}
+	}()
+
+	return lines
+}
+
+// readTTYLines reads the output of a container with a TTY, which isn't multiplexed, so all lines are reported as
+// standard output. The channel is closed when the reader is exhausted.
+func readTTYLines(reader io.Reader) <-chan LogLine {
+	lines := make(chan LogLine)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 4096), maxLogFrameSize)
+		for scanner.Scan() {
+			lines <- LogLine{Stream: Stdout, Text: string(bytes.Trim(scanner.Bytes(), "\r"))}
+		}
+
+		if err := scanner.Err(); err != nil {
+			logger.Errorf("Unexpected error reading log line: %v", err)
+		}
+	}()
+
+	return lines
+}
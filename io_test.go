@@ -1,7 +1,10 @@
 package dockerlib_test
 
 import (
+	"bytes"
 	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/google/go-cmp/cmp"
 	"strings"
 	"testing"
 )
@@ -19,3 +22,29 @@ func TestReadLinesAsBytes(t *testing.T) {
 		t.Errorf("Expected %s, got %s", exp, got)
 	}
 }
+
+func TestReadLogLines(t *testing.T) {
+	var buffer bytes.Buffer
+	stdout := stdcopy.NewStdWriter(&buffer, stdcopy.Stdout)
+	stderr := stdcopy.NewStdWriter(&buffer, stdcopy.Stderr)
+
+	_, _ = stdout.Write([]byte("first line\r\nsecond "))
+	_, _ = stderr.Write([]byte("an error\n"))
+	_, _ = stdout.Write([]byte("line\n"))
+	_, _ = stderr.Write([]byte("unterminated"))
+
+	var got []dockerlib.LogLine
+	for line := range dockerlib.ReadLogLines(&buffer) {
+		got = append(got, line)
+	}
+
+	expected := []dockerlib.LogLine{
+		{Stream: dockerlib.Stdout, Text: "first line"},
+		{Stream: dockerlib.Stderr, Text: "an error"},
+		{Stream: dockerlib.Stdout, Text: "second line"},
+		{Stream: dockerlib.Stderr, Text: "unterminated"},
+	}
+	if !cmp.Equal(got, expected) {
+		t.Errorf("unexpected lines: %s", cmp.Diff(expected, got))
+	}
+}
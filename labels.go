@@ -0,0 +1,163 @@
+package dockerlib
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"strings"
+)
+
+// SessionLabel is the label the controller adds to every container, network and volume it creates, with the ID of
+// its session (see DockerController.SessionID) as value.
+const SessionLabel = "dockerlib.session"
+
+// newSessionID returns a random (version 4) UUID.
+func newSessionID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// SessionID returns the ID of the controller's session, which is the value of SessionLabel on the resources it
+// creates. It is random unless set with WithSessionID.
+func (controller *DockerController) SessionID() string {
+	return controller.session
+}
+
+// labels returns a copy of the user labels along with the labels the controller adds to every resource it creates.
+// The controller's labels take precedence.
+func (controller *DockerController) labels(user map[string]string) map[string]string {
+	labels := make(map[string]string, len(user)+2)
+	for key, value := range user {
+		labels[key] = value
+	}
+
+	labels[ManagedLabel] = "true"
+	labels[SessionLabel] = controller.session
+	return labels
+}
+
+// sessionFilter matches the resources created by the controller's session.
+func (controller *DockerController) sessionFilter() filters.Args {
+	return filters.NewArgs(filters.Arg("label", SessionLabel+"="+controller.session))
+}
+
+// labelMounts returns a copy of the mounts in which volumes are labelled like the container, so that volumes
+// Docker creates for them are owned by the controller's session.
+func (controller *DockerController) labelMounts(mounts []mount.Mount, labels map[string]string) []mount.Mount {
+	labelled := make([]mount.Mount, len(mounts))
+	for i, m := range mounts {
+		labelled[i] = m
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+
+		var options mount.VolumeOptions
+		if m.VolumeOptions != nil {
+			options = *m.VolumeOptions
+		}
+
+		volumeLabels := controller.labels(options.Labels)
+		for key, value := range labels {
+			if _, ok := volumeLabels[key]; !ok {
+				volumeLabels[key] = value
+			}
+		}
+
+		options.Labels = volumeLabels
+		labelled[i].VolumeOptions = &options
+	}
+
+	return labelled
+}
+
+// ListContainers returns the containers created by the controller's session, whether they are running or not.
+func (controller *DockerController) ListContainers(ctx context.Context) ([]types.Container, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	containers, err := controller.cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: controller.sessionFilter()})
+	if err != nil {
+		controller.log().Errorf("Unable to list containers: %v", err)
+		return nil, DockerError{"unable to list containers", err}
+	}
+
+	return containers, nil
+}
+
+// ListNetworks returns the networks created by the controller's session.
+func (controller *DockerController) ListNetworks(ctx context.Context) ([]types.NetworkResource, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	networks, err := controller.cli.NetworkList(ctx, types.NetworkListOptions{Filters: controller.sessionFilter()})
+	if err != nil {
+		controller.log().Errorf("Unable to list networks: %v", err)
+		return nil, DockerError{"unable to list networks", err}
+	}
+
+	return networks, nil
+}
+
+// ListVolumes returns the volumes created for the mounts of containers started by the controller's session.
+func (controller *DockerController) ListVolumes(ctx context.Context) ([]*types.Volume, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := controller.cli.VolumeList(ctx, controller.sessionFilter())
+	if err != nil {
+		controller.log().Errorf("Unable to list volumes: %v", err)
+		return nil, DockerError{"unable to list volumes", err}
+	}
+
+	return resp.Volumes, nil
+}
+
+// CleanupVolumes removes the volumes created by the controller's session. Volumes are only removed once the
+// containers using them have been removed, e.g. by ShutdownAll.
+func (controller *DockerController) CleanupVolumes(ctx context.Context) error {
+	volumes, err := controller.ListVolumes(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	var allErrors []string
+	for _, volume := range volumes {
+		controller.log().Infof("Removing volume %s", volume.Name)
+		err := controller.cli.VolumeRemove(ctx, volume.Name, false)
+		if err != nil {
+			allErrors = append(allErrors, err.Error())
+		}
+	}
+
+	msg := strings.Join(allErrors, ",")
+	if len(msg) > 0 {
+		return errors.New("errors encountered when cleaning up volumes: " + msg)
+	}
+
+	return nil
+}
+
+// containerName returns the name of a listed container, without its leading slash.
+func containerName(c types.Container) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// isRunning returns whether a listed container is running.
+func isRunning(c types.Container) bool {
+	return c.State == "running"
+}
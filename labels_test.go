@@ -0,0 +1,153 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	err := controller.EnsureNetwork(ctx, "dockerlib-test", dockerlib.WithNetworkLabels(map[string]string{"team": "platform"}))
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	container := dockerlib.Container{
+		Name:    "dockerlib-test-labels",
+		Image:   TestImage,
+		Network: []string{"dockerlib-test"},
+		Labels:  map[string]string{"team": "platform", dockerlib.SessionLabel: "spoofed"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeVolume, Source: "dockerlib-test-data", Target: "/data"},
+			{Type: mount.TypeVolume, Target: "/cache", VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{"cache": "true"}}},
+		},
+	}
+	_, err = controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	want := map[string]string{
+		"team":                 "platform",
+		dockerlib.ManagedLabel: "true",
+		dockerlib.SessionLabel: controller.SessionID(),
+	}
+
+	info, _ := engine.ContainerInspect(ctx, container.ID)
+	assertLabels(t, "container", info.Config.Labels, want)
+
+	networks, _ := engine.NetworkList(ctx, types.NetworkListOptions{Filters: filters.NewArgs(filters.Arg("name", "dockerlib-test"))})
+	if len(networks) != 1 {
+		t.Fatalf("expected a single network, got %v", networks)
+	}
+	assertLabels(t, "network", networks[0].Labels, want)
+
+	volumes, _ := engine.VolumeList(ctx, filters.NewArgs())
+	if len(volumes.Volumes) != 2 {
+		t.Fatalf("expected two volumes, got %v", volumes.Volumes)
+	}
+	for _, volume := range volumes.Volumes {
+		assertLabels(t, "volume "+volume.Name, volume.Labels, want)
+	}
+
+	if container.Mounts[1].VolumeOptions.Labels[dockerlib.SessionLabel] != "" {
+		t.Error("expected mounts of the container not to be modified")
+	}
+}
+
+func TestSessionOwnership(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	ctx := context.Background()
+
+	owner := dockerlib.NewDockerControllerFromClient(engine)
+	other := dockerlib.NewDockerControllerFromClient(engine)
+	if owner.SessionID() == other.SessionID() {
+		t.Fatalf("expected controllers to have different sessions, got %s", owner.SessionID())
+	}
+
+	for i, controller := range []*dockerlib.DockerController{owner, other} {
+		name := []string{"dockerlib-test-owner", "dockerlib-test-other"}[i]
+
+		err := controller.EnsureNetwork(ctx, name)
+		if err != nil {
+			t.Fatalf("unexpected error when ensuring network: %v", err)
+		}
+
+		container := dockerlib.Container{
+			Name:   name,
+			Image:  TestImage,
+			Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: name, Target: "/data"}},
+		}
+		_, err = controller.Start(ctx, &container, nil)
+		if err != nil {
+			t.Fatalf("unexpected error when starting container: %v", err)
+		}
+	}
+
+	// a container that was created but failed to start is still owned by the session
+	engine.Fail("ContainerStart", dockerlibtest.Unavailable("unable to start"))
+	failed := dockerlib.Container{Name: "dockerlib-test-failed", Image: TestImage}
+	_, err := owner.Start(ctx, &failed, nil)
+	if err == nil {
+		t.Fatal("expected error when starting container")
+	}
+	engine.Fail("ContainerStart", nil)
+
+	containers, err := owner.ListContainers(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when listing containers: %v", err)
+	}
+	if len(containers) != 2 {
+		t.Errorf("expected the session to own two containers, got %v", containers)
+	}
+
+	for _, cleanup := range []func(context.Context) error{owner.ShutdownAll, owner.CleanupNetworks, owner.CleanupVolumes} {
+		err := cleanup(ctx)
+		if err != nil {
+			t.Errorf("unexpected error when cleaning up: %v", err)
+		}
+	}
+
+	for _, name := range []string{"dockerlib-test-owner", "dockerlib-test-failed"} {
+		if _, exists := engine.ContainerState(name); exists {
+			t.Errorf("expected container %s to be removed", name)
+		}
+	}
+	if engine.NetworkExists("dockerlib-test-owner") || engine.VolumeExists("dockerlib-test-owner") {
+		t.Error("expected network and volume of the session to be removed")
+	}
+
+	if state, _ := engine.ContainerState("dockerlib-test-other"); state != "running" {
+		t.Errorf("expected container of other session to keep running, got %s", state)
+	}
+	if !engine.NetworkExists("dockerlib-test-other") || !engine.VolumeExists("dockerlib-test-other") {
+		t.Error("expected network and volume of other session to be kept")
+	}
+}
+
+func TestWithSessionID(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithSessionID("ci-build-42"))
+
+	if controller.SessionID() != "ci-build-42" {
+		t.Errorf("expected session ci-build-42, got %s", controller.SessionID())
+	}
+}
+
+func assertLabels(t *testing.T, resource string, labels map[string]string, want map[string]string) {
+	t.Helper()
+
+	for key, value := range want {
+		if labels[key] != value {
+			t.Errorf("expected %s to have label %s=%s, got %v", resource, key, value, labels)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package dockerlib
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DefaultLogBufferSize is the number of lines of output retained for each container unless configured otherwise.
+const DefaultLogBufferSize = 1000
+
+// LogConsumer receives lines of container output.
+type LogConsumer interface {
+	Accept(line LogLine)
+}
+
+// LogFunc is an adapter to allow the use of an ordinary function as a LogConsumer.
+type LogFunc func(line LogLine)
+
+// Accept calls f(line).
+func (f LogFunc) Accept(line LogLine) {
+	f(line)
+}
+
+type logWriter struct {
+	writer  io.Writer
+	streams []LogStream
+}
+
+// LogWriter creates a LogConsumer that writes lines of output, each terminated by a newline, to the provided
+// writer. If no streams are specified, lines from both stdout and stderr are written.
+func LogWriter(writer io.Writer, streams ...LogStream) LogConsumer {
+	return logWriter{writer, streams}
+}
+
+func (w logWriter) Accept(line LogLine) {
+	if len(w.streams) > 0 {
+		found := false
+		for _, stream := range w.streams {
+			found = found || stream == line.Stream
+		}
+
+		if !found {
+			return
+		}
+	}
+
+	_, _ = io.WriteString(w.writer, line.Text+"\n")
+}
+
+// LogBuffer is a LogConsumer that retains a bounded number of the most recent lines of output. It is safe for
+// concurrent use.
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []LogLine
+	next  int
+	full  bool
+}
+
+// NewLogBuffer creates a LogBuffer that retains up to size lines.
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = DefaultLogBufferSize
+	}
+
+	return &LogBuffer{lines: make([]LogLine, size)}
+}
+
+// Accept adds a line to the buffer, discarding the oldest line if the buffer is full.
+func (b *LogBuffer) Accept(line LogLine) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	b.full = b.full || b.next == 0
+}
+
+// Lines returns a copy of the retained lines, oldest first.
+func (b *LogBuffer) Lines() []LogLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]LogLine(nil), b.lines[:b.next]...)
+	}
+
+	return append(append([]LogLine(nil), b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// String returns the text of the retained lines separated by newlines.
+func (b *LogBuffer) String() string {
+	lines := b.Lines()
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// tailSize is the number of most recent lines of output that are kept for diagnostics.
+const tailSize = 20
+
+// logFeed records the output of a container so that it can be replayed, from the beginning, to any number of
+// subscribers while the container's readiness is being determined. The most recent lines are always kept so they
+// can be reported when a container fails.
+type logFeed struct {
+	mu       sync.Mutex
+	lines    []LogLine
+	tail     *LogBuffer
+	closed   bool
+	released bool
+	changed  chan struct{}
+	ended    chan struct{}
+}
+
+func newLogFeed() *logFeed {
+	return &logFeed{tail: NewLogBuffer(tailSize), changed: make(chan struct{}), ended: make(chan struct{})}
+}
+
+// publish records a line of output and notifies all subscribers.
+func (f *logFeed) publish(line LogLine) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.released {
+		f.lines = append(f.lines, line)
+	}
+	f.tail.Accept(line)
+
+	f.notify()
+}
+
+// close indicates that there will be no further output.
+func (f *logFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	close(f.ended)
+	f.notify()
+}
+
+// lastLines returns a copy of the most recent lines of output.
+func (f *logFeed) lastLines() []LogLine {
+	return f.tail.Lines()
+}
+
+// release discards recorded output once there is no longer any need to replay it.
+func (f *logFeed) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lines = nil
+	f.released = true
+	f.notify()
+}
+
+// notify wakes up all subscribers. It must be called with the lock held.
+func (f *logFeed) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// subscribe returns a channel that receives all recorded output followed by any new output. The channel is closed
+// when the output ends, the feed is released or the context is done.
+func (f *logFeed) subscribe(ctx context.Context) <-chan LogLine {
+	lines := make(chan LogLine)
+
+	go func() {
+		defer close(lines)
+
+		next := 0
+		for {
+			f.mu.Lock()
+			if f.released {
+				f.mu.Unlock()
+				return
+			}
+			pending := f.lines[next:]
+			done := f.closed
+			changed := f.changed
+			f.mu.Unlock()
+
+			for _, line := range pending {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			next += len(pending)
+
+			if done {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return lines
+}
@@ -0,0 +1,105 @@
+package dockerlib_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func TestLogBuffer(t *testing.T) {
+	buffer := dockerlib.NewLogBuffer(2)
+	if len(buffer.Lines()) != 0 {
+		t.Errorf("expected empty buffer, got %v", buffer.Lines())
+	}
+
+	buffer.Accept(dockerlib.LogLine{Stream: dockerlib.Stdout, Text: "one"})
+	buffer.Accept(dockerlib.LogLine{Stream: dockerlib.Stdout, Text: "two"})
+	buffer.Accept(dockerlib.LogLine{Stream: dockerlib.Stderr, Text: "three"})
+
+	expected := []dockerlib.LogLine{
+		{Stream: dockerlib.Stdout, Text: "two"},
+		{Stream: dockerlib.Stderr, Text: "three"},
+	}
+	if !cmp.Equal(buffer.Lines(), expected) {
+		t.Errorf("unexpected lines: %s", cmp.Diff(expected, buffer.Lines()))
+	}
+
+	if buffer.String() != "two\nthree" {
+		t.Errorf("unexpected text %q", buffer.String())
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var out bytes.Buffer
+	consumer := dockerlib.LogWriter(&out, dockerlib.Stderr)
+
+	consumer.Accept(dockerlib.LogLine{Stream: dockerlib.Stdout, Text: "ignored"})
+	consumer.Accept(dockerlib.LogLine{Stream: dockerlib.Stderr, Text: "written"})
+
+	if out.String() != "written\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestFakeStartLogConsumers(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{
+			dockerlibtest.Stdout("starting"),
+			dockerlibtest.Stderr("warning"),
+			dockerlibtest.Stdout("ready"),
+		},
+	})
+
+	var stdout, stderr bytes.Buffer
+	var received []dockerlib.LogLine
+	container := dockerlib.Container{
+		Name:  "dockerlib-test",
+		Image: TestImage,
+		LogConsumers: []dockerlib.LogConsumer{
+			dockerlib.LogWriter(&stdout, dockerlib.Stdout),
+			dockerlib.LogWriter(&stderr, dockerlib.Stderr),
+			dockerlib.LogFunc(func(line dockerlib.LogLine) {
+				received = append(received, line)
+			}),
+		},
+		LogBufferSize: 2,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ready, err := controller.Start(ctx, &container, dockerlib.ForLog("ready"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = ready.Wait(ctx)
+	if err != nil {
+		t.Fatalf("container did not become ready: %v", err)
+	}
+
+	if stdout.String() != "starting\nready\n" || stderr.String() != "warning\n" {
+		t.Errorf("unexpected output stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+
+	if len(received) != 3 {
+		t.Errorf("expected callback to receive 3 lines, got %v", received)
+	}
+
+	expected := []dockerlib.LogLine{
+		{Stream: dockerlib.Stderr, Text: "warning"},
+		{Stream: dockerlib.Stdout, Text: "ready"},
+	}
+	if !cmp.Equal(controller.Logs("dockerlib-test"), expected) {
+		t.Errorf("unexpected buffered logs: %s", cmp.Diff(expected, controller.Logs("dockerlib-test")))
+	}
+
+	if controller.Logs("unknown") != nil {
+		t.Errorf("expected no logs for unknown container")
+	}
+}
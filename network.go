@@ -0,0 +1,25 @@
+package dockerlib
+
+// NetworkOption configures how EnsureNetwork creates a network.
+type NetworkOption func(*networkOptions)
+
+type networkOptions struct {
+	labels map[string]string
+}
+
+// WithNetworkLabels adds the given labels to the network, along with the labels the controller adds to every
+// network it creates. The labels aren't added to a network that already exists.
+func WithNetworkLabels(labels map[string]string) NetworkOption {
+	return func(o *networkOptions) {
+		o.labels = labels
+	}
+}
+
+func newNetworkOptions(opts []NetworkOption) networkOptions {
+	var options networkOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
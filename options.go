@@ -0,0 +1,189 @@
+package dockerlib
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// Option configures a DockerController created by NewDockerController.
+type Option func(*controllerOptions)
+
+type controllerOptions struct {
+	client     DockerAPI
+	host       string
+	version    string
+	negotiate  bool
+	tlsConfig  *tls.Config
+	tlsFiles   []string
+	httpClient *http.Client
+	timeout    time.Duration
+	logger     *zap.SugaredLogger
+	auth       registryAuth
+	imageCache string
+	session    string
+}
+
+// WithHost connects to the Docker daemon at the provided URL (e.g. unix:///var/run/docker.sock or
+// tcp://127.0.0.1:2376) instead of the one specified by DOCKER_HOST.
+func WithHost(host string) Option {
+	return func(o *controllerOptions) {
+		o.host = host
+	}
+}
+
+// WithAPIVersion pins the version of the Docker Engine API used by the client.
+func WithAPIVersion(version string) Option {
+	return func(o *controllerOptions) {
+		o.version = version
+	}
+}
+
+// WithAPIVersionNegotiation negotiates the Docker Engine API version with the daemon on the first request.
+func WithAPIVersionNegotiation() Option {
+	return func(o *controllerOptions) {
+		o.negotiate = true
+	}
+}
+
+// WithTLSConfig connects to the Docker daemon over TLS using the provided configuration.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *controllerOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithTLSFiles connects to the Docker daemon over TLS using the CA certificate, client certificate and key stored
+// in the provided files.
+func WithTLSFiles(caCertPath, certPath, keyPath string) Option {
+	return func(o *controllerOptions) {
+		o.tlsFiles = []string{caCertPath, certPath, keyPath}
+	}
+}
+
+// WithHTTPClient uses the provided HTTP client to communicate with the Docker daemon.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *controllerOptions) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets a default timeout that is applied to every operation the controller performs against the
+// Docker daemon, unless the operation already has its own timeout (e.g. WaitForShutdown).
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *controllerOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithLogger uses the provided logger for the controller instead of the package logger set by SetLogger.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *controllerOptions) {
+		o.logger = logger.Named("docker").Sugar()
+	}
+}
+
+// WithRegistryAuth pulls images from the given registry (e.g. docker.io or registry.example.com:5000) using the
+// provided credentials, instead of the ones found in the Docker CLI configuration.
+func WithRegistryAuth(registry string, auth types.AuthConfig) Option {
+	return func(o *controllerOptions) {
+		if o.auth.explicit == nil {
+			o.auth.explicit = make(map[string]types.AuthConfig)
+		}
+		o.auth.explicit[registry] = auth
+	}
+}
+
+// WithDockerConfig reads registry credentials from config.json in the provided directory instead of
+// $DOCKER_CONFIG or ~/.docker. Credentials stored in credential helpers (docker-credential-*) are resolved using
+// the helpers configured in the file.
+func WithDockerConfig(dir string) Option {
+	return func(o *controllerOptions) {
+		o.auth.configDir = dir
+	}
+}
+
+// WithImageCache makes EnsureImage load images from tarballs (created by `docker save` or SaveImages) in dir when
+// they can't be pulled, e.g. because the registry is unreachable. Tarballs are matched by the image references in
+// their manifest rather than by name.
+func WithImageCache(dir string) Option {
+	return func(o *controllerOptions) {
+		o.imageCache = dir
+	}
+}
+
+// WithSessionID uses the provided ID as the value of SessionLabel on the resources the controller creates, instead of
+// a random one. Controllers with the same session ID share ownership of those resources.
+func WithSessionID(id string) Option {
+	return func(o *controllerOptions) {
+		o.session = id
+	}
+}
+
+// clientOpts converts the options to the ones understood by the Docker client, in the order they need to be
+// applied.
+func (o controllerOptions) clientOpts() []client.Opt {
+	var opts []client.Opt
+	if o.httpClient != nil {
+		opts = append(opts, client.WithHTTPClient(o.httpClient))
+	}
+
+	opts = append(opts, client.FromEnv)
+
+	if len(o.host) > 0 {
+		opts = append(opts, client.WithHost(o.host))
+	}
+
+	if len(o.version) > 0 {
+		opts = append(opts, client.WithVersion(o.version))
+	}
+
+	if o.negotiate {
+		opts = append(opts, client.WithAPIVersionNegotiation())
+	}
+
+	if len(o.tlsFiles) > 0 {
+		opts = append(opts, client.WithTLSClientConfig(o.tlsFiles[0], o.tlsFiles[1], o.tlsFiles[2]))
+	}
+
+	if o.tlsConfig != nil {
+		opts = append(opts, withTLSConfig(o.tlsConfig))
+	}
+
+	return opts
+}
+
+func withTLSConfig(config *tls.Config) client.Opt {
+	return func(c *client.Client) error {
+		transport, ok := c.HTTPClient().Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("cannot apply tls config to transport: %T", c.HTTPClient().Transport)
+		}
+
+		transport.TLSClientConfig = config
+		return nil
+	}
+}
+
+// withTimeout applies the default operation timeout, if one was configured, to the provided context.
+func (controller *DockerController) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if controller.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, controller.timeout)
+}
+
+// log returns the logger configured for the controller, falling back to the package logger.
+func (controller *DockerController) log() *zap.SugaredLogger {
+	if controller.logger != nil {
+		return controller.logger
+	}
+
+	return logger
+}
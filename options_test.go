@@ -0,0 +1,97 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDockerControllerWithHost(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	server := dockerlibtest.NewServer(engine)
+	defer server.Close()
+
+	controller, err := dockerlib.NewDockerController(
+		dockerlib.WithHost(server.Host()),
+		dockerlib.WithAPIVersionNegotiation(),
+		dockerlib.WithLogger(zap.NewNop()),
+	)
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	err = controller.EnsureImage(context.Background(), "alpine")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring image: %v", err)
+	}
+
+	if !engine.HasImage("alpine") {
+		t.Errorf("expected image to be pulled from the configured host")
+	}
+}
+
+func TestNewDockerControllerWithTLSConfig(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	backend := dockerlibtest.NewServer(engine)
+	defer backend.Close()
+
+	server := httptest.NewTLSServer(backend)
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	controller, err := dockerlib.NewDockerController(
+		dockerlib.WithHost("tcp://"+server.Listener.Addr().String()),
+		dockerlib.WithAPIVersion("1.41"),
+		dockerlib.WithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		t.Fatalf("unable to create controller: %v", err)
+	}
+
+	err = controller.EnsureNetwork(context.Background(), "dockerlib")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network over TLS: %v", err)
+	}
+
+	if !engine.NetworkExists("dockerlib") {
+		t.Errorf("expected network to be created over TLS")
+	}
+}
+
+func TestNewDockerControllerInvalidHost(t *testing.T) {
+	_, err := dockerlib.NewDockerController(dockerlib.WithHost("not a host"))
+	if err == nil {
+		t.Errorf("expected error for invalid host")
+	}
+}
+
+// blockingStub blocks every network listing until the context is done.
+type blockingStub struct {
+	dockerlib.DockerAPI
+}
+
+func (s blockingStub) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestNewDockerControllerWithTimeout(t *testing.T) {
+	controller := dockerlib.NewDockerControllerFromClient(blockingStub{}, dockerlib.WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	err := controller.EnsureNetwork(context.Background(), "dockerlib")
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("expected operation to time out quickly, took %v", time.Since(start))
+	}
+}
@@ -0,0 +1,259 @@
+package dockerlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParsedLog is a line of container output decoded into a structured log entry.
+type ParsedLog struct {
+	Level   zapcore.Level
+	Message string
+	Fields  []zap.Field
+}
+
+// LogParser decodes lines of container output so they can be logged with the level and fields of the service
+// running in the container. Parse returns false if the line isn't in the format understood by the parser.
+type LogParser interface {
+	Parse(line LogLine) (ParsedLog, bool)
+}
+
+var (
+	messageKeys = []string{"msg", "message", "Message", "@message"}
+	levelKeys   = []string{"level", "lvl", "severity", "loglevel", "log.level", "levelname", "@level"}
+)
+
+// ParseLevel maps the name (or bunyan/pino style number) of a log level used by common logging libraries to the
+// equivalent zap level. Levels above error (e.g. fatal or critical) map to the error level, since logging them
+// must not terminate this process.
+func ParseLevel(level string) (zapcore.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace", "debug", "dbug", "fine", "finer", "finest", "verbose", "10", "20":
+		return zapcore.DebugLevel, true
+	case "info", "information", "informational", "notice", "30":
+		return zapcore.InfoLevel, true
+	case "warn", "warning", "40":
+		return zapcore.WarnLevel, true
+	case "error", "err", "eror", "severe", "critical", "crit", "fatal", "panic", "alert", "emerg", "emergency", "50", "60":
+		return zapcore.ErrorLevel, true
+	default:
+		return zapcore.InfoLevel, false
+	}
+}
+
+type jsonLogParser struct{}
+
+// JSONLogParser decodes lines that are JSON objects. The message and level are taken from common keys such as
+// "msg"/"message" and "level"/"severity", and all other keys become fields.
+func JSONLogParser() LogParser {
+	return jsonLogParser{}
+}
+
+func (p jsonLogParser) Parse(line LogLine) (ParsedLog, bool) {
+	text := strings.TrimSpace(line.Text)
+	if !strings.HasPrefix(text, "{") {
+		return ParsedLog{}, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewBufferString(text))
+	decoder.UseNumber()
+
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return ParsedLog{}, false
+	}
+
+	return buildParsedLog(values), true
+}
+
+type logfmtLogParser struct{}
+
+// LogfmtLogParser decodes lines in the logfmt format (key=value pairs, with optionally quoted values) that contain
+// a message or level key.
+func LogfmtLogParser() LogParser {
+	return logfmtLogParser{}
+}
+
+func (p logfmtLogParser) Parse(line LogLine) (ParsedLog, bool) {
+	values, ok := parseLogfmt(line.Text)
+	if !ok {
+		return ParsedLog{}, false
+	}
+
+	_, hasMessage := findKey(values, messageKeys)
+	_, hasLevel := findKey(values, levelKeys)
+	if !hasMessage && !hasLevel {
+		return ParsedLog{}, false
+	}
+
+	return buildParsedLog(values), true
+}
+
+var pythonLogPattern = regexp.MustCompile(`^(DEBUG|INFO|WARNING|WARN|ERROR|CRITICAL|FATAL):([^:]*):(.*)$`)
+
+type pythonLogParser struct{}
+
+// PythonLogParser decodes lines in the default format of Python's logging module ("LEVEL:name:message").
+func PythonLogParser() LogParser {
+	return pythonLogParser{}
+}
+
+func (p pythonLogParser) Parse(line LogLine) (ParsedLog, bool) {
+	matches := pythonLogPattern.FindStringSubmatch(line.Text)
+	if matches == nil {
+		return ParsedLog{}, false
+	}
+
+	level, _ := ParseLevel(matches[1])
+	return ParsedLog{
+		Level:   level,
+		Message: matches[3],
+		Fields:  []zap.Field{zap.String("logger", matches[2])},
+	}, true
+}
+
+type chainLogParser []LogParser
+
+// ChainLogParsers tries each of the provided parsers in order, using the result of the first one that understands
+// a line.
+func ChainLogParsers(parsers ...LogParser) LogParser {
+	return chainLogParser(parsers)
+}
+
+func (p chainLogParser) Parse(line LogLine) (ParsedLog, bool) {
+	for _, parser := range p {
+		if parsed, ok := parser.Parse(line); ok {
+			return parsed, true
+		}
+	}
+
+	return ParsedLog{}, false
+}
+
+// findKey returns the value of the first of the provided keys that is present.
+func findKey(values map[string]interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// buildParsedLog extracts the message and level from decoded values and converts the rest to fields.
+func buildParsedLog(values map[string]interface{}) ParsedLog {
+	parsed := ParsedLog{Level: zapcore.InfoLevel}
+	used := make(map[string]bool, 2)
+
+	for _, key := range messageKeys {
+		if value, ok := values[key]; ok {
+			if text, isString := value.(string); isString {
+				parsed.Message = text
+				used[key] = true
+				break
+			}
+		}
+	}
+
+	for _, key := range levelKeys {
+		if value, ok := values[key]; ok {
+			var name string
+			switch v := value.(type) {
+			case string:
+				name = v
+			case json.Number:
+				name = v.String()
+			}
+
+			if level, known := ParseLevel(name); known {
+				parsed.Level = level
+				used[key] = true
+				break
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		parsed.Fields = append(parsed.Fields, zap.Any(key, values[key]))
+	}
+
+	return parsed
+}
+
+// parseLogfmt splits a line into key=value pairs, returning false if it doesn't look like logfmt.
+func parseLogfmt(text string) (map[string]interface{}, bool) {
+	values := make(map[string]interface{})
+	pairs := 0
+
+	i := 0
+	for i < len(text) {
+		for i < len(text) && text[i] == ' ' {
+			i += 1
+		}
+		if i >= len(text) {
+			break
+		}
+
+		start := i
+		for i < len(text) && text[i] != '=' && text[i] != ' ' {
+			i += 1
+		}
+		key := text[start:i]
+		if len(key) == 0 {
+			return nil, false
+		}
+
+		if i >= len(text) || text[i] != '=' {
+			values[key] = true
+			continue
+		}
+		i += 1
+
+		var value string
+		if i < len(text) && text[i] == '"' {
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end += 1
+				}
+				end += 1
+			}
+			if end >= len(text) {
+				return nil, false
+			}
+
+			unquoted, err := strconv.Unquote(text[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			value = unquoted
+			i = end + 1
+		} else {
+			start = i
+			for i < len(text) && text[i] != ' ' {
+				i += 1
+			}
+			value = text[start:i]
+		}
+
+		values[key] = value
+		pairs += 1
+	}
+
+	return values, pairs > 0
+}
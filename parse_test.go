@@ -0,0 +1,141 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+	"time"
+)
+
+func fieldMap(fields []zap.Field) map[string]interface{} {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+	return encoder.Fields
+}
+
+func TestJSONLogParser(t *testing.T) {
+	line := dockerlib.LogLine{Stream: dockerlib.Stdout, Text: `{"level":"warn","msg":"disk almost full","free":42,"path":"/data"}`}
+
+	parsed, ok := dockerlib.JSONLogParser().Parse(line)
+	if !ok {
+		t.Fatal("expected JSON line to be parsed")
+	}
+
+	if parsed.Level != zapcore.WarnLevel || parsed.Message != "disk almost full" {
+		t.Errorf("unexpected level %s or message %q", parsed.Level, parsed.Message)
+	}
+
+	fields := fieldMap(parsed.Fields)
+	if len(fields) != 2 || fields["path"] != "/data" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	_, ok = dockerlib.JSONLogParser().Parse(dockerlib.LogLine{Text: "not json"})
+	if ok {
+		t.Errorf("expected plain text not to be parsed")
+	}
+}
+
+func TestJSONLogParserNumericLevel(t *testing.T) {
+	parsed, ok := dockerlib.JSONLogParser().Parse(dockerlib.LogLine{Text: `{"level":50,"msg":"failed"}`})
+	if !ok || parsed.Level != zapcore.ErrorLevel {
+		t.Errorf("expected pino error level to be mapped, got %s (%v)", parsed.Level, ok)
+	}
+}
+
+func TestLogfmtLogParser(t *testing.T) {
+	line := dockerlib.LogLine{Text: `ts=2022-03-20T10:00:00Z level=error msg="connection refused" retry=3`}
+
+	parsed, ok := dockerlib.LogfmtLogParser().Parse(line)
+	if !ok {
+		t.Fatal("expected logfmt line to be parsed")
+	}
+
+	if parsed.Level != zapcore.ErrorLevel || parsed.Message != "connection refused" {
+		t.Errorf("unexpected level %s or message %q", parsed.Level, parsed.Message)
+	}
+
+	fields := fieldMap(parsed.Fields)
+	if fields["retry"] != "3" || fields["ts"] != "2022-03-20T10:00:00Z" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	_, ok = dockerlib.LogfmtLogParser().Parse(dockerlib.LogLine{Text: "Server started on port=8000"})
+	if ok {
+		t.Errorf("expected line without message or level not to be parsed")
+	}
+}
+
+func TestPythonLogParser(t *testing.T) {
+	parsed, ok := dockerlib.PythonLogParser().Parse(dockerlib.LogLine{Text: "WARNING:root:Server started on port 8000"})
+	if !ok {
+		t.Fatal("expected python line to be parsed")
+	}
+
+	if parsed.Level != zapcore.WarnLevel || parsed.Message != "Server started on port 8000" {
+		t.Errorf("unexpected level %s or message %q", parsed.Level, parsed.Message)
+	}
+
+	if fieldMap(parsed.Fields)["logger"] != "root" {
+		t.Errorf("unexpected fields %v", parsed.Fields)
+	}
+}
+
+func TestFakeStartWithLogParser(t *testing.T) {
+	core, observed := observer.New(zapcore.DebugLevel)
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{
+			dockerlibtest.Stdout(`{"level":"error","msg":"boom"}`),
+			dockerlibtest.Stderr("CRITICAL:app:unable to start"),
+			dockerlibtest.Stdout("plain text"),
+		},
+	})
+
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithLogger(zap.New(core)))
+	defer controller.ShutdownAll(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{
+		Name:      "dockerlib-test",
+		Image:     TestImage,
+		LogParser: dockerlib.ChainLogParsers(dockerlib.JSONLogParser(), dockerlib.PythonLogParser()),
+	}
+	ready, err := controller.Start(ctx, &container, dockerlib.ForLog("plain text"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = ready.Wait(ctx)
+	if err != nil {
+		t.Fatalf("container did not become ready: %v", err)
+	}
+
+	logs := observed.Filter(func(entry observer.LoggedEntry) bool {
+		return entry.LoggerName == "docker.dockerlib-test"
+	})
+	if logs.FilterMessage("boom").FilterField(zap.Stringer("stream", dockerlib.Stdout)).Len() != 1 {
+		t.Errorf("expected JSON message to be logged")
+	}
+
+	for _, message := range []string{"boom", "unable to start"} {
+		entries := logs.FilterMessage(message).All()
+		if len(entries) != 1 || entries[0].Level != zapcore.ErrorLevel {
+			t.Errorf("expected %q to be logged once at error level, got %v", message, entries)
+		}
+	}
+
+	entries := logs.FilterMessage("plain text").All()
+	if len(entries) != 1 || entries[0].Level != zapcore.InfoLevel {
+		t.Errorf("expected unparsed line to be logged at info level, got %v", entries)
+	}
+}
@@ -1,23 +1,135 @@
 package dockerlib
 
+import "strings"
+
 // EnsureImageProgressDetail is an object to help unmarshall JSON returned from Docker during a pull.
 type EnsureImageProgressDetail struct {
 	Current int
 	Total   int
 }
 
-// EnsureImageProgress is an object to unmarshall JSON returned from Docker during a pull.
+// EnsureImageErrorDetail is an object to help unmarshall errors returned from Docker during a pull.
+type EnsureImageErrorDetail struct {
+	Code    int
+	Message string
+}
+
+// EnsureImageAux is an object to help unmarshall the result of a build returned from Docker.
+type EnsureImageAux struct {
+	ID string
+}
+
+// EnsureImageProgress is an object to unmarshall JSON returned from Docker during a pull or build.
 type EnsureImageProgress struct {
 	Status         string
 	ProgressDetail EnsureImageProgressDetail
 	Progress       string
 	ID             string
+	Error          string
+	ErrorDetail    *EnsureImageErrorDetail
+
+	// Stream is output of a build, such as the step being run or the output of a RUN instruction.
+	Stream string
+	// Aux is the result of a build, containing the ID of the image that was built.
+	Aux *EnsureImageAux
+
+	// LayerPercent is the completion (0-100) of the layer identified by ID, where downloading accounts for the
+	// first half and extracting for the second. It is only set for events about a layer.
+	LayerPercent float64 `json:"-"`
+
+	// Percent is the average completion (0-100) of all layers seen so far in the pull.
+	Percent float64 `json:"-"`
 }
 
 func (p EnsureImageProgress) String() string {
-	if len(p.ID) > 0 {
+	if len(p.Stream) > 0 {
+		return strings.TrimRight(p.Stream, "\r\n")
+	} else if len(p.ID) > 0 {
 		return p.ID + " " + p.Status + " " + p.Progress
 	} else {
 		return p.Status
 	}
 }
+
+// failure returns the error reported by Docker in this event, if any.
+func (p EnsureImageProgress) failure() (string, int, bool) {
+	if p.ErrorDetail != nil && len(p.ErrorDetail.Message) > 0 {
+		return p.ErrorDetail.Message, p.ErrorDetail.Code, true
+	}
+
+	if len(p.Error) > 0 {
+		return p.Error, 0, true
+	}
+
+	return "", 0, false
+}
+
+// pullTracker keeps track of the completion of each layer in a pull so that aggregate percentages can be
+// reported with every event.
+type pullTracker struct {
+	layers map[string]float64
+	order  []string
+}
+
+func newPullTracker() *pullTracker {
+	return &pullTracker{layers: make(map[string]float64)}
+}
+
+// track fills in the percentages of the given event based on it and all previous events.
+func (t *pullTracker) track(p *EnsureImageProgress) {
+	percent, isLayer := layerPercent(*p)
+	if isLayer {
+		previous, seen := t.layers[p.ID]
+		if !seen {
+			t.order = append(t.order, p.ID)
+		}
+		// events may arrive out of phase (e.g. a late download update), so completion never goes backwards
+		if percent < previous {
+			percent = previous
+		}
+
+		t.layers[p.ID] = percent
+		p.LayerPercent = percent
+	}
+
+	if len(t.layers) == 0 {
+		return
+	}
+
+	var total float64
+	for _, id := range t.order {
+		total += t.layers[id]
+	}
+
+	p.Percent = total / float64(len(t.layers))
+}
+
+// layerPercent returns the completion of the layer an event is about, or false if the event isn't about a layer.
+func layerPercent(p EnsureImageProgress) (float64, bool) {
+	if len(p.ID) == 0 {
+		return 0, false
+	}
+
+	fraction := func() float64 {
+		if p.ProgressDetail.Total <= 0 {
+			return 0
+		}
+		return float64(p.ProgressDetail.Current) / float64(p.ProgressDetail.Total)
+	}
+
+	switch p.Status {
+	case "Pulling fs layer", "Waiting":
+		return 0, true
+	case "Downloading":
+		return 50 * fraction(), true
+	case "Verifying Checksum", "Download complete":
+		return 50, true
+	case "Extracting":
+		return 50 + 50*fraction(), true
+	case "Pull complete", "Already exists":
+		return 100, true
+	default:
+		// e.g. "Pulling from library/alpine", where ID is the tag rather than a layer
+		return 0, false
+	}
+}
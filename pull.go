@@ -0,0 +1,111 @@
+package dockerlib
+
+import (
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PullPolicy determines whether an image is pulled when it is ensured.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image every time, which is what EnsureImage does unless another policy is given.
+	PullAlways PullPolicy = "always"
+
+	// PullIfNotPresent only pulls the image if it isn't available locally. For a reference by digest, the local
+	// image must have been pulled with that digest.
+	PullIfNotPresent PullPolicy = "if-not-present"
+
+	// PullNever never pulls the image, and fails if it isn't available locally.
+	PullNever PullPolicy = "never"
+)
+
+// PullOption configures how EnsureImage pulls an image.
+type PullOption func(*pullOptions)
+
+type pullOptions struct {
+	policy   PullPolicy
+	progress func(EnsureImageProgress)
+	auth     *types.AuthConfig
+	platform *specs.Platform
+}
+
+// WithPullAuth pulls the image using the provided credentials, instead of the ones configured for its registry.
+func WithPullAuth(auth types.AuthConfig) PullOption {
+	return func(o *pullOptions) {
+		o.auth = &auth
+	}
+}
+
+// WithPullPlatform pulls the variant of the image for the given platform (e.g. linux/arm64) instead of the platform
+// of the Docker host. With a PullPolicy other than PullAlways, a local image for another platform isn't considered
+// present.
+func WithPullPlatform(platform specs.Platform) PullOption {
+	return func(o *pullOptions) {
+		o.platform = &platform
+	}
+}
+
+// WithPullPolicy determines whether the image is pulled, instead of always pulling it.
+func WithPullPolicy(policy PullPolicy) PullOption {
+	return func(o *pullOptions) {
+		o.policy = policy
+	}
+}
+
+// WithPullProgress calls fn with every progress event of the pull, including the completion of the layer it is
+// about and of the pull as a whole. fn is called synchronously, so a slow fn slows down reading the pull.
+func WithPullProgress(fn func(EnsureImageProgress)) PullOption {
+	return func(o *pullOptions) {
+		o.progress = fn
+	}
+}
+
+func newPullOptions(opts []PullOption) pullOptions {
+	options := pullOptions{policy: PullAlways}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+// hasImage returns whether the local image matches the given reference. A reference by digest only matches if the
+// image was pulled with that digest, while a reference by tag matches whichever image currently has the tag.
+func hasImage(inspect types.ImageInspect, image string) bool {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return true
+	}
+
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return true
+	}
+
+	for _, repoDigest := range inspect.RepoDigests {
+		local, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+
+		if local, ok := local.(reference.Canonical); ok && local.Name() == canonical.Name() && local.Digest() == canonical.Digest() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// imagePlatform returns the normalized platform of the local image.
+func imagePlatform(inspect types.ImageInspect) specs.Platform {
+	return platforms.Normalize(specs.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant})
+}
+
+// matchesPlatform returns whether the local image is for the given platform, after normalizing both (e.g. aarch64
+// is arm64).
+func matchesPlatform(inspect types.ImageInspect, platform specs.Platform) bool {
+	return platforms.NewMatcher(platform).Match(imagePlatform(inspect))
+}
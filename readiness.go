@@ -0,0 +1,113 @@
+package dockerlib
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types/container"
+	"time"
+)
+
+// exitGracePeriod is how long to wait for the exit code of a container (or its final output) once its logs have
+// ended or it has exited.
+const exitGracePeriod = time.Second
+
+// Readiness is returned when starting a container and resolves once the container is ready, or once it is known
+// that it never will be.
+type Readiness struct {
+	done chan struct{}
+	err  error
+}
+
+func newReadiness() *Readiness {
+	return &Readiness{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once readiness has been determined.
+func (r *Readiness) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns nil if the container is ready, or the reason why it never will be. It also returns nil while
+// readiness is still being determined.
+func (r *Readiness) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until readiness has been determined and returns the result, or returns the error of the context if
+// it is done first.
+func (r *Readiness) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Readiness) resolve(err error) {
+	r.err = err
+	close(r.done)
+}
+
+// Helper method to wait in the background until a started container is ready according to the strategy.
+func (controller *DockerController) waitUntilReady(c Container, strategy WaitStrategy, feed *logFeed, readiness *Readiness) {
+	defer feed.release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// stop waiting as soon as the container is no longer running.
+	exited := make(chan int64, 1)
+	go func() {
+		okC, errC := controller.cli.ContainerWait(ctx, c.ID, container.WaitConditionNotRunning)
+		select {
+		case result := <-okC:
+			exited <- result.StatusCode
+			cancel()
+		case <-errC:
+		}
+	}()
+
+	target := &waitTarget{controller: controller, container: c, logs: feed}
+	err := strategy.WaitUntilReady(ctx, target)
+	if err == nil {
+		readiness.resolve(nil)
+		return
+	}
+
+	// logs usually end just before Docker reports that the container exited.
+	if errors.Is(err, ErrLogsEnded) {
+		select {
+		case code := <-exited:
+			exited <- code
+		case <-time.After(exitGracePeriod):
+		}
+	}
+
+	select {
+	case code := <-exited:
+		// give the remaining output a chance to arrive since it usually explains why the container exited.
+		select {
+		case <-feed.ended:
+		case <-time.After(exitGracePeriod):
+		}
+		err = ContainerExitedError{Name: c.Name, ExitCode: code, Logs: feed.lastLines()}
+	default:
+		switch {
+		case errors.Is(err, ErrLogsEnded):
+			err = LogsEndedError{Name: c.Name, Logs: feed.lastLines()}
+		case errors.Is(err, context.DeadlineExceeded):
+			err = ReadinessTimeoutError{Name: c.Name, Logs: feed.lastLines(), baseError: err}
+		default:
+			err = ReadinessError{Name: c.Name, Logs: feed.lastLines(), baseError: err}
+		}
+	}
+
+	controller.log().Errorf("Container %s did not become ready: %v", c.Name, err)
+	readiness.resolve(err)
+}
@@ -0,0 +1,244 @@
+package dockerlib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReaperImage is the image of the reaper started by StartReaper. Any image implementing the protocol of
+// Testcontainers' Ryuk can be used instead with WithReaperImage.
+const ReaperImage = "testcontainers/ryuk:0.3.4"
+
+// ReaperLabel is the label of the reaper container, with the ID of the session it reaps as value.
+const ReaperLabel = "dockerlib.reaper"
+
+// reaperPort is the port the reaper accepts connections on.
+const reaperPort = "8080/tcp"
+
+// reaperConnectTimeout is how long StartReaper waits for the reaper to accept the session.
+const reaperConnectTimeout = 30 * time.Second
+
+// ReaperOption configures the reaper started by StartReaper.
+type ReaperOption func(*reaperOptions)
+
+type reaperOptions struct {
+	image      string
+	socket     string
+	privileged bool
+}
+
+// WithReaperImage uses the given image for the reaper instead of ReaperImage.
+func WithReaperImage(image string) ReaperOption {
+	return func(o *reaperOptions) {
+		o.image = image
+	}
+}
+
+// WithReaperDockerSocket mounts the Docker socket at the given path on the Docker host into the reaper, instead of
+// the socket the controller is connected to (or /var/run/docker.sock when it is connected over TCP).
+func WithReaperDockerSocket(path string) ReaperOption {
+	return func(o *reaperOptions) {
+		o.socket = path
+	}
+}
+
+// WithReaperPrivileged runs the reaper as a privileged container, which is needed to access the Docker socket on
+// hosts using SELinux.
+func WithReaperPrivileged() ReaperOption {
+	return func(o *reaperOptions) {
+		o.privileged = true
+	}
+}
+
+// Reaper is a connection to a reaper container, which removes all containers, networks and volumes labelled with
+// the session of the controller that started it once the connection is closed, whether by Close or because the
+// process exited.
+type Reaper struct {
+	// Name is the name of the reaper container.
+	Name string
+
+	conn      net.Conn
+	closeOnce sync.Once
+}
+
+// Close disconnects from the reaper, which then removes the resources of the session and exits.
+func (r *Reaper) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		err = r.conn.Close()
+	})
+
+	return err
+}
+
+// StartReaper starts a reaper container, similar to Testcontainers' Ryuk, that removes the containers, networks and
+// volumes of the controller's session (see SessionLabel) once the process disconnects from it. The connection is
+// kept open until Reaper.Close is called or the process exits, so resources are cleaned up even if ShutdownAll is
+// never called because the process panicked or was killed. The reaper is not part of the session itself, so it
+// isn't affected by ShutdownAll.
+func (controller *DockerController) StartReaper(ctx context.Context, opts ...ReaperOption) (*Reaper, error) {
+	options := reaperOptions{image: ReaperImage, socket: controller.dockerSocket()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	err := controller.EnsureImage(ctx, options.image, WithPullPolicy(PullIfNotPresent))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	name := "dockerlib-reaper-" + controller.session
+	containerConfig := container.Config{
+		Image:        options.image,
+		ExposedPorts: nat.PortSet{reaperPort: struct{}{}},
+		Labels:       map[string]string{ManagedLabel: "true", ReaperLabel: controller.session},
+	}
+	hostConfig := container.HostConfig{
+		AutoRemove:   true,
+		Privileged:   options.privileged,
+		Mounts:       []mount.Mount{{Type: mount.TypeBind, Source: options.socket, Target: "/var/run/docker.sock"}},
+		PortBindings: nat.PortMap{reaperPort: []nat.PortBinding{{}}},
+	}
+
+	controller.log().Infof("Starting reaper %s for session %s", name, controller.session)
+	resp, err := controller.cli.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, name)
+	if err != nil {
+		controller.log().Errorf("Unable to create reaper %s: %v", name, err)
+		return nil, ContainerError{"unable to create reaper", name, err}
+	}
+
+	// AutoRemove only applies once the reaper has started, and it doesn't exit until a session connects to it, so
+	// it has to be removed if it can't be started or connected to
+	connected := false
+	defer func() {
+		if !connected {
+			controller.removeReaper(name, resp.ID)
+		}
+	}()
+
+	err = controller.cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err != nil {
+		controller.log().Errorf("Unable to start reaper %s: %v", name, err)
+		return nil, ContainerError{"unable to start reaper", name, err}
+	}
+
+	info, err := controller.cli.ContainerInspect(ctx, resp.ID)
+	if err != nil {
+		controller.log().Errorf("Unable to inspect reaper %s: %v", name, err)
+		return nil, ContainerError{"unable to inspect reaper", name, err}
+	}
+
+	var port string
+	if info.NetworkSettings != nil {
+		for _, binding := range info.NetworkSettings.Ports[reaperPort] {
+			if hostPort, err := strconv.Atoi(binding.HostPort); err == nil && hostPort != 0 {
+				port = binding.HostPort
+				break
+			}
+		}
+	}
+	if len(port) == 0 {
+		err := fmt.Errorf("port %s is not published", reaperPort)
+		controller.log().Errorf("Unable to connect to reaper %s: %v", name, err)
+		return nil, ContainerError{"unable to connect to reaper", name, err}
+	}
+
+	conn, err := controller.connectReaper(ctx, net.JoinHostPort(controller.host(), port))
+	if err != nil {
+		controller.log().Errorf("Unable to connect to reaper %s: %v", name, err)
+		return nil, ContainerError{"unable to connect to reaper", name, err}
+	}
+
+	connected = true
+	reaper := &Reaper{Name: name, conn: conn}
+	go func() {
+		// the reaper never writes after acknowledging the session, so reading only returns once disconnected
+		_, err := conn.Read(make([]byte, 1))
+		if !errors.Is(err, net.ErrClosed) {
+			controller.log().Warnf("Reaper %s disconnected, resources of session %s won't be cleaned up if the process exits: %v", name, controller.session, err)
+		}
+	}()
+
+	return reaper, nil
+}
+
+// connectReaper connects to the reaper at address, retrying until it is listening, and registers the
+// controller's session with it.
+func (controller *DockerController) connectReaper(ctx context.Context, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, reaperConnectTimeout)
+	defer cancel()
+
+	filter := url.Values{"label": []string{SessionLabel + "=" + controller.session}}.Encode()
+
+	var conn net.Conn
+	err := poll(ctx, "reaper to accept session", func(ctx context.Context) (bool, error) {
+		var dialer net.Dialer
+		c, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return false, nil
+		}
+
+		deadline, _ := ctx.Deadline()
+		_ = c.SetDeadline(deadline)
+
+		_, err = c.Write([]byte(filter + "\n"))
+		if err == nil {
+			var ack string
+			ack, err = bufio.NewReader(c).ReadString('\n')
+			if err == nil && strings.TrimSpace(ack) != "ACK" {
+				err = fmt.Errorf("unexpected response %q", ack)
+			}
+		}
+		if err != nil {
+			// the reaper may accept connections before it is ready, so try again
+			_ = c.Close()
+			return false, nil
+		}
+
+		_ = c.SetDeadline(time.Time{})
+		conn = c
+		return true, nil
+	})
+
+	return conn, err
+}
+
+// removeReaper force-removes a reaper that couldn't be started or connected to. It uses its own context, since
+// the one StartReaper was called with may be why it failed.
+func (controller *DockerController) removeReaper(name string, id string) {
+	ctx, cancel := controller.withTimeout(context.Background())
+	defer cancel()
+
+	err := controller.cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		controller.log().Warnf("Unable to remove reaper %s: %v", name, err)
+	}
+}
+
+// dockerSocket returns the path of the Docker socket on the Docker host.
+func (controller *DockerController) dockerSocket() string {
+	hostURL, err := client.ParseHostURL(controller.cli.DaemonHost())
+	if err == nil && hostURL.Scheme == "unix" {
+		// the path of a unix socket is parsed as the host
+		return hostURL.Host
+	}
+
+	return "/var/run/docker.sock"
+}
@@ -0,0 +1,164 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types/mount"
+	"testing"
+	"time"
+)
+
+func TestReaper(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	ctx := context.Background()
+
+	controller := dockerlib.NewDockerControllerFromClient(engine)
+	other := dockerlib.NewDockerControllerFromClient(engine)
+	defer other.ShutdownAll(ctx)
+
+	reaper, err := controller.StartReaper(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when starting reaper: %v", err)
+	}
+
+	if !engine.HasImage(dockerlib.ReaperImage) {
+		t.Errorf("expected reaper image to be pulled")
+	}
+
+	err = controller.EnsureNetwork(ctx, "dockerlib-test")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	containers := []dockerlib.Container{
+		{
+			Name:    "dockerlib-test-reaped",
+			Image:   TestImage,
+			Network: []string{"dockerlib-test"},
+			Mounts:  []mount.Mount{{Type: mount.TypeVolume, Source: "dockerlib-test-data", Target: "/data"}},
+		},
+		{Name: "dockerlib-test-kept", Image: TestImage},
+	}
+	for i, owner := range []*dockerlib.DockerController{controller, other} {
+		_, err := owner.Start(ctx, &containers[i], nil)
+		if err != nil {
+			t.Fatalf("unexpected error when starting container: %v", err)
+		}
+	}
+
+	// the reaper is not part of the session, so it outlives ShutdownAll
+	err = controller.ShutdownAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down containers: %v", err)
+	}
+	if state, _ := engine.ContainerState(reaper.Name); state != "running" {
+		t.Fatalf("expected reaper to be running after ShutdownAll, got %s", state)
+	}
+
+	// containers left behind, e.g. by a process that was killed, are reaped once it disconnects
+	_, err = controller.Start(ctx, &containers[0], nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = reaper.Close()
+	if err != nil {
+		t.Fatalf("unexpected error when closing reaper: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, exists := engine.ContainerState(reaper.Name); !exists {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, exists := engine.ContainerState(reaper.Name); exists {
+		t.Fatal("expected reaper to exit and be removed")
+	}
+	if _, exists := engine.ContainerState("dockerlib-test-reaped"); exists {
+		t.Error("expected container of the session to be reaped")
+	}
+	if engine.NetworkExists("dockerlib-test") || engine.VolumeExists("dockerlib-test-data") {
+		t.Error("expected network and volume of the session to be reaped")
+	}
+	if state, _ := engine.ContainerState("dockerlib-test-kept"); state != "running" {
+		t.Errorf("expected container of other session to keep running, got %s", state)
+	}
+}
+
+func TestReaperDockerSocket(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		opts []dockerlib.ReaperOption
+		want string
+	}{
+		{"unix socket", "unix:///run/user/1000/docker.sock", nil, "/run/user/1000/docker.sock"},
+		{"tcp", "tcp://127.0.0.1:2376", nil, "/var/run/docker.sock"},
+		{"override", "unix:///run/user/1000/docker.sock", []dockerlib.ReaperOption{dockerlib.WithReaperDockerSocket("/var/run/docker.sock")}, "/var/run/docker.sock"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := dockerlibtest.NewEngine()
+			engine.Host = test.host
+			controller := dockerlib.NewDockerControllerFromClient(engine)
+
+			reaper, err := controller.StartReaper(context.Background(), test.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error when starting reaper: %v", err)
+			}
+			defer reaper.Close()
+
+			info, err := engine.ContainerInspect(context.Background(), reaper.Name)
+			if err != nil {
+				t.Fatalf("unexpected error when inspecting reaper: %v", err)
+			}
+
+			if len(info.Mounts) != 1 || info.Mounts[0].Source != test.want || info.Mounts[0].Destination != "/var/run/docker.sock" {
+				t.Errorf("expected %s to be mounted into the reaper, got %+v", test.want, info.Mounts)
+			}
+
+			if !info.HostConfig.AutoRemove || info.Config.Labels[dockerlib.ReaperLabel] != controller.SessionID() {
+				t.Errorf("unexpected reaper configuration: %+v %+v", info.HostConfig, info.Config.Labels)
+			}
+		})
+	}
+}
+
+func TestReaperRemovedOnFailure(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(dockerlib.ReaperImage, "alpine:latest")
+	controller := dockerlib.NewDockerControllerFromClient(engine)
+	ctx := context.Background()
+	name := "dockerlib-reaper-" + controller.SessionID()
+
+	engine.Fail("ContainerStart", dockerlibtest.Conflict("port is already allocated"))
+	_, err := controller.StartReaper(ctx)
+	if err == nil {
+		t.Fatal("expected error when the reaper can't be started")
+	}
+	if _, exists := engine.ContainerState(name); exists {
+		t.Error("expected reaper that failed to start to be removed")
+	}
+	engine.Fail("ContainerStart", nil)
+
+	// an image that doesn't implement the reaper's protocol can't be connected to
+	_, err = controller.StartReaper(ctx, dockerlib.WithReaperImage("alpine:latest"))
+	if err == nil {
+		t.Fatal("expected error when the reaper can't be connected to")
+	}
+	if _, exists := engine.ContainerState(name); exists {
+		t.Error("expected reaper that couldn't be connected to to be removed")
+	}
+
+	reaper, err := controller.StartReaper(ctx)
+	if err != nil {
+		t.Fatalf("expected reaper to start after earlier failures, got %v", err)
+	}
+	_ = reaper.Close()
+}
@@ -0,0 +1,119 @@
+package dockerlib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/go-units"
+)
+
+// minMemory is the smallest memory limit Docker accepts for a container.
+const minMemory = 6 * 1024 * 1024
+
+// ulimitNames are the resource limits that can be set with Ulimit.
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true, "memlock": true, "msgqueue": true,
+	"nice": true, "nofile": true, "nproc": true, "rss": true, "rtprio": true, "rttime": true, "sigpending": true,
+	"stack": true,
+}
+
+// Ulimit is a resource limit (see setrlimit(2)) applied to the processes in a container, e.g. nofile.
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// Resources limits the resources a container can use. Zero values don't limit the resource.
+type Resources struct {
+	// Memory is the memory limit in bytes, which must be at least 6MB.
+	Memory int64
+	// MemorySwap is the limit of memory and swap combined in bytes, which must be at least Memory, or -1 for
+	// unlimited swap. It requires Memory to be set.
+	MemorySwap int64
+	// NanoCPUs is the number of CPUs the container can use, in units of 10^-9 CPUs, e.g. 1500000000 for 1.5 CPUs.
+	NanoCPUs int64
+	// CPUShares is the weight of the container relative to other containers when CPUs are contended.
+	CPUShares int64
+	// CpusetCpus are the CPUs the container can run on, e.g. "0-3" or "0,2".
+	CpusetCpus string
+	// PidsLimit is the maximum number of processes in the container, or -1 for unlimited.
+	PidsLimit int64
+	// ShmSize is the size of /dev/shm in bytes. Docker defaults to 64MB.
+	ShmSize int64
+	// Ulimits are the resource limits of the processes in the container.
+	Ulimits []Ulimit
+}
+
+// validate returns an error describing the first limit Docker would reject.
+func (r Resources) validate() error {
+	if r.Memory < 0 || (r.Memory > 0 && r.Memory < minMemory) {
+		return fmt.Errorf("memory limit must be at least %s, got %d bytes", units.BytesSize(minMemory), r.Memory)
+	}
+
+	switch {
+	case r.MemorySwap < -1:
+		return fmt.Errorf("memory swap limit must be at least -1, got %d", r.MemorySwap)
+	case r.MemorySwap != 0 && r.Memory == 0:
+		return errors.New("memory swap limit requires a memory limit")
+	case r.MemorySwap > 0 && r.MemorySwap < r.Memory:
+		return fmt.Errorf("memory swap limit (%d bytes) must be at least the memory limit (%d bytes)", r.MemorySwap, r.Memory)
+	}
+
+	if r.NanoCPUs < 0 {
+		return fmt.Errorf("CPU limit must not be negative, got %d", r.NanoCPUs)
+	}
+
+	if r.CPUShares < 0 {
+		return fmt.Errorf("CPU shares must not be negative, got %d", r.CPUShares)
+	}
+
+	if len(r.CpusetCpus) > 0 {
+		if _, err := parsers.ParseUintList(r.CpusetCpus); err != nil {
+			return fmt.Errorf("invalid cpuset %q: %v", r.CpusetCpus, err)
+		}
+	}
+
+	if r.PidsLimit < -1 {
+		return fmt.Errorf("pids limit must be at least -1, got %d", r.PidsLimit)
+	}
+
+	if r.ShmSize < 0 {
+		return fmt.Errorf("shm size must not be negative, got %d", r.ShmSize)
+	}
+
+	seen := make(map[string]bool)
+	for _, ulimit := range r.Ulimits {
+		switch {
+		case !ulimitNames[ulimit.Name]:
+			return fmt.Errorf("invalid ulimit %q", ulimit.Name)
+		case seen[ulimit.Name]:
+			return fmt.Errorf("ulimit %s is set more than once", ulimit.Name)
+		case ulimit.Soft > ulimit.Hard:
+			return fmt.Errorf("ulimit %s soft limit (%d) must not be greater than hard limit (%d)", ulimit.Name, ulimit.Soft, ulimit.Hard)
+		}
+		seen[ulimit.Name] = true
+	}
+
+	return nil
+}
+
+// apply sets the limits on the host configuration of a container.
+func (r Resources) apply(hostConfig *container.HostConfig) {
+	hostConfig.Memory = r.Memory
+	hostConfig.MemorySwap = r.MemorySwap
+	hostConfig.NanoCPUs = r.NanoCPUs
+	hostConfig.CPUShares = r.CPUShares
+	hostConfig.CpusetCpus = r.CpusetCpus
+	hostConfig.ShmSize = r.ShmSize
+
+	if r.PidsLimit != 0 {
+		limit := r.PidsLimit
+		hostConfig.PidsLimit = &limit
+	}
+
+	for _, ulimit := range r.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &units.Ulimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+	}
+}
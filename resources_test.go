@@ -0,0 +1,108 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/docker/go-units"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestResources(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	container := dockerlib.Container{
+		Name:  "dockerlib-test-resources",
+		Image: TestImage,
+		Resources: dockerlib.Resources{
+			Memory:     512 * units.MiB,
+			MemorySwap: units.GiB,
+			NanoCPUs:   1500000000,
+			CPUShares:  512,
+			CpusetCpus: "0-1",
+			PidsLimit:  100,
+			ShmSize:    256 * units.MiB,
+			Ulimits:    []dockerlib.Ulimit{{Name: "nofile", Soft: 1024, Hard: 4096}},
+		},
+	}
+	_, err := controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	info, err := engine.ContainerInspect(ctx, container.ID)
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting container: %v", err)
+	}
+
+	hostConfig := info.HostConfig
+	if hostConfig.Memory != 512*units.MiB || hostConfig.MemorySwap != units.GiB || hostConfig.NanoCPUs != 1500000000 ||
+		hostConfig.CPUShares != 512 || hostConfig.CpusetCpus != "0-1" || hostConfig.ShmSize != 256*units.MiB {
+		t.Errorf("unexpected resources: %+v", hostConfig.Resources)
+	}
+
+	if hostConfig.PidsLimit == nil || *hostConfig.PidsLimit != 100 {
+		t.Errorf("expected pids limit 100, got %v", hostConfig.PidsLimit)
+	}
+
+	want := []*units.Ulimit{{Name: "nofile", Soft: 1024, Hard: 4096}}
+	if !cmp.Equal(hostConfig.Ulimits, want) {
+		t.Errorf("unexpected ulimits: %s", cmp.Diff(want, hostConfig.Ulimits))
+	}
+}
+
+func TestResourcesUnlimited(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	container := dockerlib.Container{Name: "dockerlib-test-resources", Image: TestImage}
+	_, err := controller.Start(ctx, &container, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	info, _ := engine.ContainerInspect(ctx, container.ID)
+	resources := info.HostConfig.Resources
+	if resources.Memory != 0 || resources.NanoCPUs != 0 || resources.PidsLimit != nil || resources.Ulimits != nil || info.HostConfig.ShmSize != 0 {
+		t.Errorf("expected no resource limits, got %+v", resources)
+	}
+}
+
+func TestResourcesInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources dockerlib.Resources
+	}{
+		{"memory below minimum", dockerlib.Resources{Memory: units.MiB}},
+		{"swap without memory", dockerlib.Resources{MemorySwap: units.GiB}},
+		{"swap below memory", dockerlib.Resources{Memory: units.GiB, MemorySwap: 512 * units.MiB}},
+		{"negative cpus", dockerlib.Resources{NanoCPUs: -1}},
+		{"negative cpu shares", dockerlib.Resources{CPUShares: -2}},
+		{"invalid cpuset", dockerlib.Resources{CpusetCpus: "3-1"}},
+		{"invalid pids limit", dockerlib.Resources{PidsLimit: -2}},
+		{"negative shm size", dockerlib.Resources{ShmSize: -1}},
+		{"unknown ulimit", dockerlib.Resources{Ulimits: []dockerlib.Ulimit{{Name: "files", Soft: 1, Hard: 1}}}},
+		{"soft ulimit above hard", dockerlib.Resources{Ulimits: []dockerlib.Ulimit{{Name: "nproc", Soft: 2, Hard: 1}}}},
+		{"duplicate ulimit", dockerlib.Resources{Ulimits: []dockerlib.Ulimit{{Name: "nproc", Soft: 1, Hard: 1}, {Name: "nproc", Soft: 2, Hard: 2}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller, engine := newFakeController(t)
+
+			container := dockerlib.Container{Name: "dockerlib-test-resources", Image: TestImage, Resources: test.resources}
+			_, err := controller.Start(context.Background(), &container, nil)
+
+			var containerErr dockerlib.ContainerError
+			if !errors.As(err, &containerErr) {
+				t.Errorf("expected ContainerError, got %v", err)
+			}
+
+			if engine.Calls("ContainerCreate") != 0 {
+				t.Error("expected container not to be created with invalid resources")
+			}
+		})
+	}
+}
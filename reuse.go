@@ -0,0 +1,154 @@
+package dockerlib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+)
+
+// ConfigHashLabel is the label of a container started with Reuse, with a hash of its configuration as value. A
+// container is only reused if the hash of the Container it is started for is the same.
+const ConfigHashLabel = "dockerlib.config-hash"
+
+// configHash returns a hash of the parts of the container's configuration that require it to be recreated when they
+// change: the image (by ID, so pulling a newer image changes it), environment, ports, mounts, command and networks
+// (also by ID, since a container can't be started again once a network it is attached to has been recreated).
+func (controller *DockerController) configHash(ctx context.Context, c Container) (string, error) {
+	image, _, err := controller.cli.ImageInspectWithRaw(ctx, c.Image)
+	if err != nil {
+		controller.log().Errorf("Unable to inspect image %s: %v", c.Image, err)
+		return "", ContainerError{"unable to inspect image for container", c.Name, err}
+	}
+
+	networks := make(map[string]string, len(c.Network))
+	if len(c.Network) > 0 {
+		list, err := controller.cli.NetworkList(ctx, types.NetworkListOptions{})
+		if err != nil {
+			controller.log().Errorf("Unable to list networks: %v", err)
+			return "", DockerError{"unable to list networks", err}
+		}
+
+		for _, name := range c.Network {
+			// a missing network is reported when it is attached
+			networks[name] = ""
+		}
+		for _, nw := range list {
+			if _, ok := networks[nw.Name]; ok {
+				networks[nw.Name] = nw.ID
+			}
+		}
+	}
+
+	config := struct {
+		Image       string
+		Environment []string
+		Ports       map[int]int
+		Mounts      []mount.Mount
+		Command     []string
+		Network     map[string]string
+	}{image.ID, c.Environment, c.Ports, c.Mounts, c.Command, networks}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", ContainerError{"unable to hash configuration of container", c.Name, err}
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reuse starts or keeps the existing container with the name of c if it was started with the same configuration,
+// setting the ID of c, and returns whether it did along with how its logs should be followed. An existing container
+// with another configuration is removed so that it can be recreated, while one that wasn't started with Reuse is left
+// alone.
+func (controller *DockerController) reuse(ctx context.Context, c *Container, hash string) (bool, followOptions, error) {
+	info, err := controller.cli.ContainerInspect(ctx, c.Name)
+	if errdefs.IsNotFound(err) {
+		return false, followOptions{}, nil
+	}
+	if err != nil {
+		controller.log().Errorf("Unable to inspect container %s: %v", c.Name, err)
+		return false, followOptions{}, ContainerError{"unable to inspect container", c.Name, err}
+	}
+
+	var existing string
+	if info.Config != nil {
+		existing = info.Config.Labels[ConfigHashLabel]
+	}
+
+	switch {
+	case len(existing) == 0:
+		return false, followOptions{}, nil
+	case existing != hash:
+		controller.log().Infof("Configuration of container %s changed, recreating it", c.Name)
+		err := controller.cli.ContainerRemove(ctx, info.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil {
+			controller.log().Errorf("Unable to remove container %s: %v", c.Name, err)
+			return false, followOptions{}, ContainerError{"unable to remove container", c.Name, err}
+		}
+		return false, followOptions{}, nil
+	}
+
+	c.ID = info.ID
+	if info.State != nil && info.State.Running {
+		controller.log().Infof("Reusing running container %s", c)
+		return true, followOptions{}, nil
+	}
+
+	controller.log().Infof("Reusing container %s, starting it", c)
+	err = controller.cli.ContainerStart(ctx, info.ID, types.ContainerStartOptions{})
+	if err != nil {
+		controller.log().Errorf("Unable to start container %s: %v", c, err)
+		return false, followOptions{}, ContainerError{"unable to start container", c.Name, err}
+	}
+
+	// the logs of the previous run are skipped, so that readiness isn't determined from them
+	info, err = controller.cli.ContainerInspect(ctx, info.ID)
+	if err != nil {
+		controller.log().Errorf("Unable to inspect container %s: %v", c, err)
+		return false, followOptions{}, ContainerError{"unable to inspect container", c.Name, err}
+	}
+
+	var follow followOptions
+	if info.State != nil {
+		follow.since = info.State.StartedAt
+	}
+
+	return true, follow, nil
+}
+
+// reusedNetworks returns the names of the reusable containers, running or not, by the ID of the networks they are
+// attached to. These networks outlive the session like the containers, since a stopped container can't be started
+// again once one of its networks is removed.
+func (controller *DockerController) reusedNetworks(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	containers, err := controller.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ConfigHashLabel)),
+	})
+	if err != nil {
+		controller.log().Errorf("Unable to list containers: %v", err)
+		return nil, DockerError{"unable to list containers", err}
+	}
+
+	reused := make(map[string]string)
+	for _, c := range containers {
+		if c.NetworkSettings == nil {
+			continue
+		}
+		for _, endpoint := range c.NetworkSettings.Networks {
+			if endpoint != nil && len(endpoint.NetworkID) > 0 {
+				reused[endpoint.NetworkID] = containerName(c)
+			}
+		}
+	}
+
+	return reused, nil
+}
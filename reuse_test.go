@@ -0,0 +1,273 @@
+package dockerlib_test
+
+import (
+	"context"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types/mount"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReuse(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	ctx := context.Background()
+
+	newContainer := func() dockerlib.Container {
+		return dockerlib.Container{
+			Name:        "dockerlib-test-db",
+			Image:       TestImage,
+			Ports:       map[int]int{5432: 15432},
+			Environment: []string{"POSTGRES_PASSWORD=secret"},
+			Mounts:      []mount.Mount{{Type: mount.TypeVolume, Source: "dockerlib-test-db", Target: "/var/lib/postgresql/data"}},
+			Reuse:       true,
+		}
+	}
+
+	first := dockerlib.NewDockerControllerFromClient(engine)
+	original := newContainer()
+	_, err := first.Start(ctx, &original, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	info, _ := engine.ContainerInspect(ctx, original.ID)
+	if info.Config.Labels[dockerlib.SessionLabel] != "" || info.Config.Labels[dockerlib.ConfigHashLabel] == "" {
+		t.Errorf("expected reusable container to be labelled with its configuration instead of the session, got %v", info.Config.Labels)
+	}
+
+	volumes, _ := first.ListVolumes(ctx)
+	if len(volumes) != 0 {
+		t.Errorf("expected volumes of reusable container not to be part of the session, got %v", volumes)
+	}
+
+	err = first.ShutdownAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down containers: %v", err)
+	}
+	if state, _ := engine.ContainerState(original.Name); state != "running" {
+		t.Fatalf("expected reusable container to survive ShutdownAll, got %s", state)
+	}
+
+	// the next run keeps the running container
+	second := dockerlib.NewDockerControllerFromClient(engine)
+	reused := newContainer()
+	ready, err := second.Start(ctx, &reused, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when reusing container: %v", err)
+	}
+	if err := ready.Wait(ctx); err != nil {
+		t.Fatalf("expected reused container to be ready: %v", err)
+	}
+	if reused.ID != original.ID || engine.Calls("ContainerCreate") != 1 {
+		t.Errorf("expected container %s to be reused, got %s", original.ID, reused.ID)
+	}
+
+	// a stopped container is started again
+	_ = engine.Exit(original.Name, 0)
+	restarted := newContainer()
+	_, err = second.Start(ctx, &restarted, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when reusing stopped container: %v", err)
+	}
+	if state, _ := engine.ContainerState(original.Name); restarted.ID != original.ID || state != "running" {
+		t.Errorf("expected stopped container to be started again, got %s (%s)", restarted.ID, state)
+	}
+
+	// a container with another configuration is recreated
+	changed := newContainer()
+	changed.Environment = append(changed.Environment, "POSTGRES_DB=test")
+	_, err = second.Start(ctx, &changed, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when recreating container: %v", err)
+	}
+	if changed.ID == original.ID || engine.Calls("ContainerCreate") != 2 {
+		t.Errorf("expected container with changed configuration to be recreated, got %s", changed.ID)
+	}
+	if _, err := engine.ContainerInspect(ctx, original.ID); err == nil {
+		t.Error("expected outdated container to be removed")
+	}
+	if !engine.VolumeExists("dockerlib-test-db") {
+		t.Error("expected named volume to be kept when recreating container")
+	}
+
+	err = second.Shutdown(ctx, changed)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down container: %v", err)
+	}
+	err = second.Remove(ctx, changed)
+	if err != nil {
+		t.Fatalf("unexpected error when removing container: %v", err)
+	}
+}
+
+func TestReuseIgnoresOtherContainers(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	existing := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage}
+	_, err := controller.Start(ctx, &existing, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	reusable := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage, Reuse: true}
+	_, err = controller.Start(ctx, &reusable, nil)
+	if err == nil {
+		t.Fatal("expected error when a container that wasn't started with Reuse has the same name")
+	}
+
+	if state, _ := engine.ContainerState(existing.ID); state != "running" {
+		t.Errorf("expected existing container to be left alone, got %s", state)
+	}
+}
+
+func TestReuseMissingImage(t *testing.T) {
+	controller, _ := newFakeController(t)
+
+	c := dockerlib.Container{Name: "dockerlib-test-db", Image: "missing:latest", Reuse: true}
+	_, err := controller.Start(context.Background(), &c, nil)
+	if err == nil {
+		t.Fatal("expected error when the image of a reusable container doesn't exist")
+	}
+}
+
+func TestReuseStoppedContainerLogs(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	engine.Script("dockerlib-test-db", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{
+			dockerlibtest.Stdout("starting"),
+			dockerlibtest.Stdout("ready to accept connections").After(200 * time.Millisecond),
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first := dockerlib.NewDockerControllerFromClient(engine)
+	original := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage, Reuse: true}
+	ready, err := first.Start(ctx, &original, dockerlib.ForLog("ready to accept connections"))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+	if err := ready.Wait(ctx); err != nil {
+		t.Fatalf("expected container to be ready: %v", err)
+	}
+	_ = engine.Exit(original.Name, 0)
+
+	// readiness of the restarted container isn't determined from the output of its previous run
+	second := dockerlib.NewDockerControllerFromClient(engine)
+	restarted := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage, Reuse: true}
+	begin := time.Now()
+	ready, err = second.Start(ctx, &restarted, dockerlib.ForLog("ready to accept connections"))
+	if err != nil {
+		t.Fatalf("unexpected error when reusing stopped container: %v", err)
+	}
+	if err := ready.Wait(ctx); err != nil {
+		t.Fatalf("expected restarted container to be ready: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed < 200*time.Millisecond {
+		t.Errorf("expected restarted container to be ready once it logged again, got ready after %v", elapsed)
+	}
+
+	expected := []dockerlib.LogLine{
+		{Stream: dockerlib.Stdout, Text: "starting"},
+		{Stream: dockerlib.Stdout, Text: "ready to accept connections"},
+	}
+	if got := second.Logs(restarted.Name); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected only the lines of the restarted container %v, got %v", expected, got)
+	}
+}
+
+func TestReuseKeepsNetworks(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"dockerlib-test-dev", "dockerlib-test-other"} {
+		err := controller.EnsureNetwork(ctx, name)
+		if err != nil {
+			t.Fatalf("unexpected error when ensuring network: %v", err)
+		}
+	}
+
+	c := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage, Network: []string{"dockerlib-test-dev"}, Reuse: true}
+	_, err := controller.Start(ctx, &c, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	err = controller.ShutdownAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when shutting down containers: %v", err)
+	}
+	err = controller.CleanupNetworks(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when cleaning up networks: %v", err)
+	}
+
+	if !engine.NetworkExists("dockerlib-test-dev") {
+		t.Error("expected network of reusable container to be kept")
+	}
+	if engine.NetworkExists("dockerlib-test-other") {
+		t.Error("expected other network of the session to be removed")
+	}
+	if state, _ := engine.ContainerState(c.Name); state != "running" {
+		t.Errorf("expected reusable container to keep running, got %s", state)
+	}
+}
+
+func TestReuseRecreatedNetwork(t *testing.T) {
+	engine := dockerlibtest.NewEngine()
+	engine.AddImage(TestImage)
+	ctx := context.Background()
+
+	first := dockerlib.NewDockerControllerFromClient(engine)
+	err := first.EnsureNetwork(ctx, "dockerlib-test-dev")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	original := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage, Network: []string{"dockerlib-test-dev"}, Reuse: true}
+	_, err = first.Start(ctx, &original, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+	_ = engine.Exit(original.Name, 0)
+
+	err = first.CleanupNetworks(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error when cleaning up networks: %v", err)
+	}
+	if !engine.NetworkExists("dockerlib-test-dev") {
+		t.Fatal("expected network of stopped reusable container to be kept")
+	}
+
+	// e.g. removed by the reaper, which a stopped container doesn't prevent
+	err = engine.NetworkRemove(ctx, "dockerlib-test-dev")
+	if err != nil {
+		t.Fatalf("unexpected error when removing network: %v", err)
+	}
+
+	second := dockerlib.NewDockerControllerFromClient(engine)
+	defer second.CleanupNetworks(ctx)
+	err = second.EnsureNetwork(ctx, "dockerlib-test-dev")
+	if err != nil {
+		t.Fatalf("unexpected error when ensuring network: %v", err)
+	}
+
+	recreated := dockerlib.Container{Name: "dockerlib-test-db", Image: TestImage, Network: []string{"dockerlib-test-dev"}, Reuse: true}
+	_, err = second.Start(ctx, &recreated, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when starting container after its network was recreated: %v", err)
+	}
+	defer second.Remove(ctx, recreated)
+
+	if recreated.ID == original.ID {
+		t.Error("expected container to be recreated since its network was recreated")
+	}
+	if state, _ := engine.ContainerState(recreated.Name); state != "running" {
+		t.Errorf("expected recreated container to be running, got %s", state)
+	}
+}
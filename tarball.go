@@ -0,0 +1,200 @@
+package dockerlib
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/docker/distribution/reference"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarballManifest is an entry of the manifest.json written by `docker save`.
+type tarballManifest struct {
+	Config   string
+	RepoTags []string
+}
+
+// SaveImages writes the given images to w as a tarball, like `docker save`.
+func (controller *DockerController) SaveImages(ctx context.Context, w io.Writer, images ...string) error {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	controller.log().Infof("Saving images %v", images)
+	reader, err := controller.cli.ImageSave(ctx, images)
+	if err != nil {
+		controller.log().Errorf("Unable to save images %v: %v", images, err)
+		return ImageError{"unable to save image", strings.Join(images, ", "), err}
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		controller.log().Errorf("Unable to save images %v: %v", images, err)
+		return ImageError{"unable to save image", strings.Join(images, ", "), err}
+	}
+
+	return nil
+}
+
+// SaveImagesToFile writes the given images to a tarball at path, replacing the file if it already exists.
+func (controller *DockerController) SaveImagesToFile(ctx context.Context, path string, images ...string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		controller.log().Errorf("Unable to create %s: %v", path, err)
+		return DockerError{"unable to create " + path, err}
+	}
+
+	err = controller.SaveImages(ctx, file, images...)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = DockerError{"unable to write " + path, closeErr}
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// LoadImages loads the images in a tarball (optionally gzipped) created by `docker save` or SaveImages, like
+// `docker load`, returning the references (or IDs, for untagged images) of the images that were loaded.
+func (controller *DockerController) LoadImages(ctx context.Context, r io.Reader) ([]string, error) {
+	ctx, cancel := controller.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := controller.cli.ImageLoad(ctx, r, false)
+	if err != nil {
+		controller.log().Errorf("Unable to load images: %v", err)
+		return nil, DockerError{"unable to load images", err}
+	}
+	defer resp.Body.Close()
+
+	var loaded []string
+	msg, _, failed, err := controller.readProgress(resp.Body, func(progress EnsureImageProgress) {
+		line := progress.String()
+		for _, prefix := range []string{"Loaded image: ", "Loaded image ID: "} {
+			if strings.HasPrefix(line, prefix) {
+				loaded = append(loaded, strings.TrimPrefix(line, prefix))
+			}
+		}
+		controller.log().Info(progress)
+	})
+	if failed {
+		controller.log().Errorf("Unable to load images: %s", msg)
+		return nil, DockerError{"unable to load images", errors.New(msg)}
+	}
+	if err != nil {
+		controller.log().Errorf("Unable to read progress of loading images: %v", err)
+		return nil, DockerError{"unable to read progress of loading images", err}
+	}
+
+	return loaded, nil
+}
+
+// LoadImagesFromFile loads the images in the tarball at path.
+func (controller *DockerController) LoadImagesFromFile(ctx context.Context, path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		controller.log().Errorf("Unable to open %s: %v", path, err)
+		return nil, DockerError{"unable to open " + path, err}
+	}
+	defer file.Close()
+
+	return controller.LoadImages(ctx, file)
+}
+
+// loadFromCache loads the image from a tarball in the image cache directory, returning whether one contained it.
+func (controller *DockerController) loadFromCache(ctx context.Context, image string) (bool, error) {
+	if len(controller.imageCache) == 0 {
+		return false, nil
+	}
+
+	path, err := findCachedImage(controller.imageCache, image)
+	if err != nil || len(path) == 0 {
+		return false, err
+	}
+
+	controller.log().Infof("Loading image %s from %s", image, path)
+	_, err = controller.LoadImagesFromFile(ctx, path)
+	return err == nil, err
+}
+
+// findCachedImage returns the path of the first tarball in dir whose manifest contains the image, or an empty path
+// if there isn't one.
+func findCachedImage(dir string, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	wanted := reference.FamiliarString(reference.TagNameOnly(named))
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		manifests, err := readTarballManifest(path)
+		if err != nil {
+			continue
+		}
+
+		for _, manifest := range manifests {
+			for _, tag := range manifest.RepoTags {
+				if named, err := reference.ParseNormalizedNamed(tag); err == nil && reference.FamiliarString(reference.TagNameOnly(named)) == wanted {
+					return path, nil
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// readTarballManifest reads manifest.json from a tarball created by `docker save`, which may be gzipped.
+func readTarballManifest(path string) ([]tarballManifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReader(file)
+	if magic, err := reader.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name != "manifest.json" {
+			continue
+		}
+
+		var manifests []tarballManifest
+		err = json.NewDecoder(archive).Decode(&manifests)
+		return manifests, err
+	}
+}
@@ -0,0 +1,138 @@
+package dockerlib_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveAndLoadImages(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+	id := buildTestImage(t, controller, "example/app:1.0", map[string]string{"team": "platform"})
+
+	var tarball bytes.Buffer
+	err := controller.SaveImages(ctx, &tarball, "example/app:1.0", TestImage)
+	if err != nil {
+		t.Fatalf("unexpected error when saving images: %v", err)
+	}
+
+	for _, image := range []string{"example/app:1.0", TestImage} {
+		err = controller.RemoveImage(ctx, image, dockerlib.RemoveImageOptions{Force: true})
+		if err != nil {
+			t.Fatalf("unexpected error when removing image %s: %v", image, err)
+		}
+	}
+
+	// docker load accepts gzipped tarballs too
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write(tarball.Bytes())
+	_ = gz.Close()
+
+	loaded, err := controller.LoadImages(ctx, &compressed)
+	if err != nil {
+		t.Fatalf("unexpected error when loading images: %v", err)
+	}
+
+	if strings.Join(loaded, ",") != "example/app:1.0,"+TestImage {
+		t.Errorf("unexpected loaded images: %v", loaded)
+	}
+
+	info, err := controller.ImageInspect(ctx, "example/app:1.0")
+	if err != nil {
+		t.Fatalf("unexpected error when inspecting loaded image: %v", err)
+	}
+
+	if info.ID != id || info.Labels["team"] != "platform" || strings.Join(info.Cmd, " ") != "serve" {
+		t.Errorf("expected loaded image to keep its ID and configuration, got %+v", info)
+	}
+
+	if !engine.HasImage(TestImage) {
+		t.Errorf("expected image %s to be loaded", TestImage)
+	}
+}
+
+func TestSaveAndLoadImagesFile(t *testing.T) {
+	controller, engine := newFakeController(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "images.tar")
+
+	err := controller.SaveImagesToFile(ctx, path, TestImage)
+	if err != nil {
+		t.Fatalf("unexpected error when saving images: %v", err)
+	}
+
+	err = controller.RemoveImage(ctx, TestImage, dockerlib.RemoveImageOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error when removing image: %v", err)
+	}
+
+	_, err = controller.LoadImagesFromFile(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error when loading images: %v", err)
+	}
+
+	if !engine.HasImage(TestImage) {
+		t.Errorf("expected image %s to be loaded", TestImage)
+	}
+
+	err = controller.SaveImagesToFile(ctx, filepath.Join(t.TempDir(), "missing.tar"), "missing")
+	if err == nil {
+		t.Error("expected error when saving missing image")
+	}
+}
+
+func TestEnsureImageFallsBackToCache(t *testing.T) {
+	cache := t.TempDir()
+
+	// seed the cache from another engine, as an air-gapped agent would be
+	seed := dockerlibtest.NewEngine()
+	seed.AddImage("alpine:3.15")
+	seeder := dockerlib.NewDockerControllerFromClient(seed)
+	err := seeder.SaveImagesToFile(context.Background(), filepath.Join(cache, "base-images.tar"), "alpine:3.15")
+	if err != nil {
+		t.Fatalf("unable to seed cache: %v", err)
+	}
+	_ = os.WriteFile(filepath.Join(cache, "notes.txt"), []byte("not a tarball"), 0644)
+
+	engine := dockerlibtest.NewEngine()
+	engine.Fail("ImagePull", dockerlibtest.Unavailable("registry-1.docker.io: no such host"))
+	controller := dockerlib.NewDockerControllerFromClient(engine, dockerlib.WithDockerConfig(t.TempDir()), dockerlib.WithImageCache(cache))
+
+	err = controller.EnsureImage(context.Background(), "alpine:3.15")
+	if err != nil {
+		t.Fatalf("expected image to be loaded from cache, got %v", err)
+	}
+
+	if !engine.HasImage("alpine:3.15") {
+		t.Error("expected image to be loaded from cache")
+	}
+
+	err = controller.EnsureImage(context.Background(), "busybox")
+
+	var pullErr dockerlib.ImagePullError
+	if !errors.As(err, &pullErr) || !strings.Contains(err.Error(), "not found in image cache") {
+		t.Errorf("expected ImagePullError for image missing from cache, got %v", err)
+	}
+
+	// a pull that failed because the context is done isn't retried from the cache
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	engine.Fail("ImagePull", ctx.Err())
+
+	err = controller.EnsureImage(ctx, "alpine:3.15")
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "not loaded from image cache") {
+		t.Errorf("expected canceled pull not to fall back to the cache, got %v", err)
+	}
+	if engine.Calls("ImageLoad") != 1 {
+		t.Errorf("expected image to be loaded from cache once, got %d", engine.Calls("ImageLoad"))
+	}
+}
@@ -0,0 +1,375 @@
+package dockerlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often polling strategies check whether a container is ready.
+const pollInterval = 100 * time.Millisecond
+
+// WaitStrategy determines when a started container is ready to be used.
+type WaitStrategy interface {
+	// WaitUntilReady blocks until the target is ready, returning an error if it never will be or the context is
+	// done first.
+	WaitUntilReady(ctx context.Context, target WaitTarget) error
+}
+
+// WaitTarget provides a WaitStrategy with access to the container it is waiting for.
+type WaitTarget interface {
+	// Container returns the container that was started.
+	Container() Container
+	// Logs returns a channel with all output of the container since it started, which is closed when the output
+	// ends or the context is done.
+	Logs(ctx context.Context) <-chan LogLine
+	// Host returns the host on which published ports of the container can be reached.
+	Host() string
+	// MappedPort returns the host port that the provided container port is published on.
+	MappedPort(ctx context.Context, port int) (int, error)
+	// Inspect returns the current low-level information about the container.
+	Inspect(ctx context.Context) (types.ContainerJSON, error)
+	// Exec runs a command inside the container and returns its exit code.
+	Exec(ctx context.Context, cmd []string) (int, error)
+}
+
+// ErrLogsEnded is returned by log based strategies when the container output ended before it was ready.
+var ErrLogsEnded = errors.New("logs ended before container was ready")
+
+// LogStrategy waits until a line of the container's output matches.
+type LogStrategy struct {
+	description string
+	match       func(line string) bool
+	occurrence  int
+	stream      LogStream
+}
+
+// ForLog waits until the container outputs a line that contains the provided text.
+func ForLog(text string) *LogStrategy {
+	return &LogStrategy{
+		description: fmt.Sprintf("log containing %q", text),
+		match:       func(line string) bool { return strings.Contains(line, text) },
+		occurrence:  1,
+	}
+}
+
+// ForLogMatching waits until the container outputs a line that matches the provided regular expression.
+func ForLogMatching(pattern *regexp.Regexp) *LogStrategy {
+	return &LogStrategy{
+		description: fmt.Sprintf("log matching %q", pattern),
+		match:       pattern.MatchString,
+		occurrence:  1,
+	}
+}
+
+// WithOccurrence waits until the nth matching line instead of the first. Values of n below 1 are treated as 1, so
+// at least one matching line is always required.
+func (s *LogStrategy) WithOccurrence(n int) *LogStrategy {
+	if n < 1 {
+		n = 1
+	}
+
+	s.occurrence = n
+	return s
+}
+
+// OnStream only matches lines written to the provided stream instead of both stdout and stderr.
+func (s *LogStrategy) OnStream(stream LogStream) *LogStrategy {
+	s.stream = stream
+	s.description += " on " + stream.String()
+	return s
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s *LogStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	count := 0
+	for line := range target.Logs(ctx) {
+		if s.stream != 0 && line.Stream != s.stream {
+			continue
+		}
+
+		if s.match(line.Text) {
+			count += 1
+		}
+
+		if count >= s.occurrence {
+			return nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("waiting for %s: %w", s.description, ctx.Err())
+	}
+
+	return fmt.Errorf("waiting for %s: %w", s.description, ErrLogsEnded)
+}
+
+func (s *LogStrategy) String() string {
+	return s.description
+}
+
+// ListeningPortStrategy waits until a published port of the container accepts TCP connections.
+type ListeningPortStrategy struct {
+	port int
+}
+
+// ForListeningPort waits until the published container port accepts TCP connections.
+func ForListeningPort(port int) *ListeningPortStrategy {
+	return &ListeningPortStrategy{port: port}
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s *ListeningPortStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	hostPort, err := target.MappedPort(ctx, s.port)
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(target.Host(), strconv.Itoa(hostPort))
+	var dialer net.Dialer
+	return poll(ctx, fmt.Sprintf("port %d listening", s.port), func(ctx context.Context) (bool, error) {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return false, nil
+		}
+
+		_ = conn.Close()
+		return true, nil
+	})
+}
+
+// HTTPStrategy waits until an HTTP endpoint of the container returns the expected status code.
+type HTTPStrategy struct {
+	path       string
+	port       int
+	method     string
+	statusCode int
+}
+
+// ForHTTP waits until a GET request for the provided path returns 200 OK. If the container publishes more than
+// one port, WithPort should be used to select which one to send the request to.
+func ForHTTP(path string) *HTTPStrategy {
+	return &HTTPStrategy{path: path, method: http.MethodGet, statusCode: http.StatusOK}
+}
+
+// WithPort sends the request to the provided container port.
+func (s *HTTPStrategy) WithPort(port int) *HTTPStrategy {
+	s.port = port
+	return s
+}
+
+// WithMethod sends requests with the provided HTTP method instead of GET.
+func (s *HTTPStrategy) WithMethod(method string) *HTTPStrategy {
+	s.method = method
+	return s
+}
+
+// WithStatusCode waits for the provided status code instead of 200 OK.
+func (s *HTTPStrategy) WithStatusCode(statusCode int) *HTTPStrategy {
+	s.statusCode = statusCode
+	return s
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s *HTTPStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	port := s.port
+	if port == 0 {
+		ports := target.Container().Ports
+		if len(ports) != 1 {
+			return fmt.Errorf("unable to determine port for HTTP request to %s, container publishes %d ports", s.path, len(ports))
+		}
+
+		for from := range ports {
+			port = from
+		}
+	}
+
+	hostPort, err := target.MappedPort(ctx, port)
+	if err != nil {
+		return err
+	}
+
+	endpoint := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(target.Host(), strconv.Itoa(hostPort)),
+		Path:   s.path,
+	}
+	httpClient := http.Client{Timeout: time.Second}
+
+	description := fmt.Sprintf("%s %s returning %d", s.method, s.path, s.statusCode)
+	return poll(ctx, description, func(ctx context.Context) (bool, error) {
+		request, err := http.NewRequestWithContext(ctx, s.method, endpoint.String(), nil)
+		if err != nil {
+			return false, err
+		}
+
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return false, nil
+		}
+		_ = response.Body.Close()
+
+		return response.StatusCode == s.statusCode, nil
+	})
+}
+
+// HealthStrategy waits until Docker reports the container as healthy according to its HEALTHCHECK.
+type HealthStrategy struct{}
+
+// ForHealthy waits until Docker reports the container as healthy. The container image (or configuration) must
+// define a health check.
+func ForHealthy() *HealthStrategy {
+	return &HealthStrategy{}
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s *HealthStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	return poll(ctx, "container to be healthy", func(ctx context.Context) (bool, error) {
+		info, err := target.Inspect(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if info.State == nil || info.State.Health == nil {
+			return false, errors.New("container " + target.Container().Name + " does not have a health check")
+		}
+
+		return info.State.Health.Status == types.Healthy, nil
+	})
+}
+
+// ExecStrategy waits until a command executed inside the container exits with code 0.
+type ExecStrategy struct {
+	cmd []string
+}
+
+// ForExec waits until the provided command, executed inside the container, exits with code 0.
+func ForExec(cmd ...string) *ExecStrategy {
+	return &ExecStrategy{cmd: cmd}
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s *ExecStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	description := fmt.Sprintf("%v to exit with code 0", s.cmd)
+	return poll(ctx, description, func(ctx context.Context) (bool, error) {
+		exitCode, err := target.Exec(ctx, s.cmd)
+		if err != nil {
+			return false, err
+		}
+
+		return exitCode == 0, nil
+	})
+}
+
+type allStrategy struct {
+	strategies []WaitStrategy
+}
+
+// ForAll waits until all the provided strategies report the container as ready. If any of them fail, the
+// remaining ones are cancelled.
+func ForAll(strategies ...WaitStrategy) WaitStrategy {
+	return allStrategy{strategies}
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s allStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(s.strategies))
+	for _, strategy := range s.strategies {
+		go func(strategy WaitStrategy) {
+			errs <- strategy.WaitUntilReady(ctx, target)
+		}(strategy)
+	}
+
+	for range s.strategies {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type anyStrategy struct {
+	strategies []WaitStrategy
+}
+
+// ForAny waits until any of the provided strategies reports the container as ready, cancelling the remaining
+// ones. It fails only if all of them fail.
+func ForAny(strategies ...WaitStrategy) WaitStrategy {
+	return anyStrategy{strategies}
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s anyStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(s.strategies))
+	for _, strategy := range s.strategies {
+		go func(strategy WaitStrategy) {
+			errs <- strategy.WaitUntilReady(ctx, target)
+		}(strategy)
+	}
+
+	var messages []string
+	for range s.strategies {
+		err := <-errs
+		if err == nil {
+			return nil
+		}
+		messages = append(messages, err.Error())
+	}
+
+	return errors.New("no strategy succeeded: " + strings.Join(messages, ","))
+}
+
+type timeoutStrategy struct {
+	strategy WaitStrategy
+	timeout  time.Duration
+}
+
+// WithStartupTimeout limits how long the provided strategy can take to report the container as ready.
+func WithStartupTimeout(strategy WaitStrategy, timeout time.Duration) WaitStrategy {
+	return timeoutStrategy{strategy, timeout}
+}
+
+// WaitUntilReady implements WaitStrategy.
+func (s timeoutStrategy) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.strategy.WaitUntilReady(ctx, target)
+}
+
+// poll calls check every pollInterval until it reports success or an error, or the context is done.
+func poll(ctx context.Context, description string, check func(ctx context.Context) (bool, error)) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := check(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			return fmt.Errorf("waiting for %s: %w", description, err)
+		case ok:
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", description, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package dockerlib_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ATenderholt/dockerlib"
+	"github.com/ATenderholt/dockerlib/dockerlibtest"
+	"github.com/docker/docker/api/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubTarget is a WaitTarget that doesn't need a container at all.
+type stubTarget struct {
+	container dockerlib.Container
+	lines     []dockerlib.LogLine
+	inspect   func() (types.ContainerJSON, error)
+	exec      func(cmd []string) (int, error)
+}
+
+func (t stubTarget) Container() dockerlib.Container {
+	return t.container
+}
+
+func (t stubTarget) Logs(ctx context.Context) <-chan dockerlib.LogLine {
+	lines := make(chan dockerlib.LogLine)
+	go func() {
+		defer close(lines)
+		for _, line := range t.lines {
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+func (t stubTarget) Host() string {
+	return "127.0.0.1"
+}
+
+func (t stubTarget) MappedPort(ctx context.Context, port int) (int, error) {
+	return t.container.Ports[port], nil
+}
+
+func (t stubTarget) Inspect(ctx context.Context) (types.ContainerJSON, error) {
+	return t.inspect()
+}
+
+func (t stubTarget) Exec(ctx context.Context, cmd []string) (int, error) {
+	return t.exec(cmd)
+}
+
+func stdout(texts ...string) []dockerlib.LogLine {
+	lines := make([]dockerlib.LogLine, len(texts))
+	for i, text := range texts {
+		lines[i] = dockerlib.LogLine{Stream: dockerlib.Stdout, Text: text}
+	}
+	return lines
+}
+
+func listenerPort(t *testing.T, addr net.Addr) int {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatalf("unable to get port of %s: %v", addr, err)
+	}
+
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func TestForLogOccurrence(t *testing.T) {
+	target := stubTarget{lines: stdout("ready 1", "other", "ready 2")}
+
+	err := dockerlib.ForLog("ready").WithOccurrence(2).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for second occurrence: %v", err)
+	}
+
+	err = dockerlib.ForLog("ready").WithOccurrence(3).WaitUntilReady(context.Background(), target)
+	if !errors.Is(err, dockerlib.ErrLogsEnded) {
+		t.Errorf("expected logs to end before third occurrence, got %v", err)
+	}
+
+	// at least one matching line is required
+	for _, n := range []int{0, -1} {
+		err = dockerlib.ForLog("missing").WithOccurrence(n).WaitUntilReady(context.Background(), target)
+		if !errors.Is(err, dockerlib.ErrLogsEnded) {
+			t.Errorf("expected occurrence %d to require a matching line, got %v", n, err)
+		}
+	}
+}
+
+func TestForLogOnStream(t *testing.T) {
+	target := stubTarget{lines: []dockerlib.LogLine{
+		{Stream: dockerlib.Stdout, Text: "ready"},
+		{Stream: dockerlib.Stderr, Text: "warming up"},
+	}}
+
+	err := dockerlib.ForLog("ready").OnStream(dockerlib.Stderr).WaitUntilReady(context.Background(), target)
+	if !errors.Is(err, dockerlib.ErrLogsEnded) {
+		t.Errorf("expected stdout line to be ignored, got %v", err)
+	}
+
+	err = dockerlib.ForLog("warming").OnStream(dockerlib.Stderr).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for stderr line: %v", err)
+	}
+}
+
+func TestForLogMatching(t *testing.T) {
+	target := stubTarget{lines: stdout("starting", "listening on port 5432")}
+
+	err := dockerlib.ForLogMatching(regexp.MustCompile(`port \d+$`)).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for matching line: %v", err)
+	}
+}
+
+func TestForListeningPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	port := listenerPort(t, listener.Addr())
+	target := stubTarget{container: dockerlib.Container{Ports: map[int]int{5432: port}}}
+
+	err = dockerlib.WithStartupTimeout(dockerlib.ForListeningPort(5432), time.Second).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for listening port: %v", err)
+	}
+
+	_ = listener.Close()
+	err = dockerlib.WithStartupTimeout(dockerlib.ForListeningPort(5432), 200*time.Millisecond).WaitUntilReady(context.Background(), target)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout waiting for closed port, got %v", err)
+	}
+}
+
+func TestForHTTP(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || atomic.AddInt32(&requests, 1) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	port := listenerPort(t, server.Listener.Addr())
+	target := stubTarget{container: dockerlib.Container{Ports: map[int]int{8080: port}}}
+
+	strategy := dockerlib.ForHTTP("/health").WithStatusCode(http.StatusNoContent)
+	err := dockerlib.WithStartupTimeout(strategy, 2*time.Second).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for HTTP endpoint: %v", err)
+	}
+}
+
+func TestForHealthy(t *testing.T) {
+	var calls int32
+	target := stubTarget{inspect: func() (types.ContainerJSON, error) {
+		status := types.Starting
+		if atomic.AddInt32(&calls, 1) > 2 {
+			status = types.Healthy
+		}
+		return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{Health: &types.Health{Status: status}},
+		}}, nil
+	}}
+
+	err := dockerlib.WithStartupTimeout(dockerlib.ForHealthy(), 2*time.Second).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for healthy container: %v", err)
+	}
+}
+
+func TestForHealthyWithoutHealthCheck(t *testing.T) {
+	target := stubTarget{inspect: func() (types.ContainerJSON, error) {
+		return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{}}}, nil
+	}}
+
+	err := dockerlib.ForHealthy().WaitUntilReady(context.Background(), target)
+	if err == nil {
+		t.Errorf("expected error for container without health check")
+	}
+}
+
+func TestForExec(t *testing.T) {
+	var calls int32
+	target := stubTarget{exec: func(cmd []string) (int, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return 1, nil
+		}
+		return 0, nil
+	}}
+
+	err := dockerlib.WithStartupTimeout(dockerlib.ForExec("pg_isready"), 2*time.Second).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for command: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("expected command to be executed 3 times, got %d", calls)
+	}
+}
+
+func TestForAllAndForAny(t *testing.T) {
+	target := stubTarget{lines: stdout("first", "second")}
+
+	err := dockerlib.ForAll(dockerlib.ForLog("first"), dockerlib.ForLog("second")).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for all strategies: %v", err)
+	}
+
+	err = dockerlib.ForAll(dockerlib.ForLog("first"), dockerlib.ForLog("third")).WaitUntilReady(context.Background(), target)
+	if err == nil {
+		t.Errorf("expected error when one strategy fails")
+	}
+
+	err = dockerlib.ForAny(dockerlib.ForLog("third"), dockerlib.ForLog("second")).WaitUntilReady(context.Background(), target)
+	if err != nil {
+		t.Errorf("unexpected error waiting for any strategy: %v", err)
+	}
+
+	err = dockerlib.ForAny(dockerlib.ForLog("third"), dockerlib.ForLog("fourth")).WaitUntilReady(context.Background(), target)
+	if err == nil {
+		t.Errorf("expected error when all strategies fail")
+	}
+}
+
+func TestFakeStartWaitingForExecAndLog(t *testing.T) {
+	controller, engine := newFakeController(t)
+	engine.Script("dockerlib-test", dockerlibtest.Script{
+		Lines: []dockerlibtest.Line{dockerlibtest.Stdout("INFO:root:Hello!").After(50 * time.Millisecond)},
+	})
+
+	var execs int32
+	engine.OnExec(func(exec dockerlibtest.Exec) dockerlibtest.ExecResult {
+		if atomic.AddInt32(&execs, 1) < 2 {
+			return dockerlibtest.ExecResult{ExitCode: 1}
+		}
+		return dockerlibtest.ExecResult{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := dockerlib.Container{Name: "dockerlib-test", Image: TestImage}
+	ready, err := controller.Start(ctx, &container, dockerlib.ForAll(dockerlib.ForExec("true"), dockerlib.ForLog("Hello")))
+	if err != nil {
+		t.Fatalf("unexpected error when starting container: %v", err)
+	}
+
+	select {
+	case <-ready.Done():
+		if err := ready.Err(); err != nil {
+			t.Fatalf("container did not become ready: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Test timeout - container didn't become ready.")
+	}
+}